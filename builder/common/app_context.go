@@ -4,7 +4,7 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	kubevirtv1 "kubevirt.io/api/core/v1"
-	exportv1 "kubevirt.io/api/export/v1alpha1"
+	exportv1 "kubevirt.io/api/export/v1beta1"
 	"packer-plugin-kubevirt/builder/common/vm"
 )
 
@@ -14,10 +14,15 @@ const (
 	PackerHook                StateBagEntry = "hook"
 	PackerUi                  StateBagEntry = "ui"
 	PackerError               StateBagEntry = "error"
+	BuildId                   StateBagEntry = "buildid"
+	ImageName                 StateBagEntry = "imagename"
 	VirtualMachine            StateBagEntry = "vm"
 	VirtualMachineOsFamily    StateBagEntry = "vmosfamily"
 	VirtualMachineExport      StateBagEntry = "vmexport"
 	VirtualMachineExportToken StateBagEntry = "vmexporttoken"
+	CommunicatorHost          StateBagEntry = "commhost"
+	CommunicatorPort          StateBagEntry = "commport"
+	OutputFormat              StateBagEntry = "outputformat"
 
 	VirtualMachineHost     = "127.0.0.1"
 	VirtualMachineUsername = "packer"
@@ -42,6 +47,41 @@ func (s *AppContext) GetPackerUi() packersdk.Ui {
 	return s.get(PackerUi).(packersdk.Ui)
 }
 
+func (s *AppContext) GetBuildId() string {
+	buildId := s.get(BuildId)
+	if buildId != nil {
+		return buildId.(string)
+	}
+	return ""
+}
+
+// GetImageName returns the name given by the user to the image, resource names may carry an extra unique suffix
+func (s *AppContext) GetImageName() string {
+	imageName := s.get(ImageName)
+	if imageName != nil {
+		return imageName.(string)
+	}
+	return ""
+}
+
+// GetCommunicatorHost returns the address resolved by the connectivity step, defaulting to the local port-forwarding
+func (s *AppContext) GetCommunicatorHost() string {
+	host := s.get(CommunicatorHost)
+	if host != nil {
+		return host.(string)
+	}
+	return VirtualMachineHost
+}
+
+// GetCommunicatorPort returns the port resolved by the connectivity step, zero if none was resolved
+func (s *AppContext) GetCommunicatorPort() int {
+	port := s.get(CommunicatorPort)
+	if port != nil {
+		return port.(int)
+	}
+	return 0
+}
+
 func (s *AppContext) GetVirtualMachine() *kubevirtv1.VirtualMachine {
 	vm := s.get(VirtualMachine)
 	if vm != nil {
@@ -70,13 +110,27 @@ func (s *AppContext) GetVirtualMachineExportToken() string {
 	return s.get(VirtualMachineExportToken).(string)
 }
 
-func (s *AppContext) BuildArtifact(builderId string) packersdk.Artifact {
+// GetOutputFormat returns the format of the converted image, empty when the disks of the VM are exported
+func (s *AppContext) GetOutputFormat() string {
+	format := s.get(OutputFormat)
+	if format != nil {
+		return format.(string)
+	}
+	return ""
+}
+
+func (s *AppContext) BuildArtifact(builderId string, destroy func() error) packersdk.Artifact {
 	return &KubevirtArtifact{
 		BuilderIdValue: builderId,
+		destroy:        destroy,
 		StateData: map[string]interface{}{
 			NamespaceArtifactKey:                 s.GetVirtualMachineExport().Namespace,
+			VirtualMachineNameArtifactKey:        s.GetVirtualMachine().Name,
 			VirtualMachineExportNameArtifactKey:  s.GetVirtualMachineExport().Name,
 			VirtualMachineExportTokenArtifactKey: s.GetVirtualMachineExportToken(),
+			ImageNameArtifactKey:                 s.GetImageName(),
+			BuildIdArtifactKey:                   s.GetBuildId(),
+			OutputFormatArtifactKey:              s.GetOutputFormat(),
 		},
 	}
 }
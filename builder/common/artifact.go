@@ -5,8 +5,12 @@ package common
 
 const (
 	NamespaceArtifactKey                 = "namespace"
+	VirtualMachineNameArtifactKey        = "vm"
 	VirtualMachineExportNameArtifactKey  = "vmexport"
 	VirtualMachineExportTokenArtifactKey = "token"
+	ImageNameArtifactKey                 = "imagename"
+	BuildIdArtifactKey                   = "buildid"
+	OutputFormatArtifactKey              = "outputformat"
 )
 
 // KubevirtArtifact packersdk.KubevirtArtifact implementation
@@ -16,6 +20,8 @@ type KubevirtArtifact struct {
 	// StateData should store data such as GeneratedData
 	// to be common with post-processors
 	StateData map[string]interface{}
+	// destroy releases the resources kept for post-processors, Packer calls it once all of them have run
+	destroy func() error
 }
 
 func (a *KubevirtArtifact) BuilderId() string {
@@ -39,5 +45,8 @@ func (a *KubevirtArtifact) State(name string) interface{} {
 }
 
 func (a *KubevirtArtifact) Destroy() error {
-	return nil
+	if a.destroy == nil {
+		return nil
+	}
+	return a.destroy()
 }
@@ -0,0 +1,23 @@
+package common
+
+// CompletionSignal defines how a build without communicator detects that the guest has finished configuring itself
+type CompletionSignal string
+
+const (
+	// CompletionSignalPowerOff waits for the guest to power itself off, e.g. with 'power_state' in cloud-init
+	CompletionSignalPowerOff CompletionSignal = "poweroff"
+	// CompletionSignalGuestAgent waits for a command run through the guest agent to succeed
+	CompletionSignalGuestAgent CompletionSignal = "guest_agent"
+)
+
+// DefaultCompletionCommand succeeds once cloud-init has run every module, 'runcmd' included
+var DefaultCompletionCommand = []string{"test", "-f", "/var/lib/cloud/instance/boot-finished"}
+
+var completionSignals = []CompletionSignal{
+	CompletionSignalPowerOff,
+	CompletionSignalGuestAgent,
+}
+
+func ValidateCompletionSignal(field string, signal CompletionSignal) error {
+	return ValidateOneOf(field, signal, completionSignals)
+}
@@ -0,0 +1,26 @@
+package common
+
+// ConflictPolicy defines what happens when a resource the build is about to create already exists
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyFail halts the build
+	ConflictPolicyFail ConflictPolicy = "fail"
+	// ConflictPolicyRecreate deletes the existing resource, waits for it to be gone and creates a new one
+	ConflictPolicyRecreate ConflictPolicy = "recreate"
+	// ConflictPolicyReuse keeps the existing resource and carries on with it
+	ConflictPolicyReuse ConflictPolicy = "reuse"
+	// ConflictPolicySuffix appends the build ID to the names of the resources created by the build
+	ConflictPolicySuffix ConflictPolicy = "suffix"
+)
+
+var conflictPolicies = []ConflictPolicy{
+	ConflictPolicyFail,
+	ConflictPolicyRecreate,
+	ConflictPolicyReuse,
+	ConflictPolicySuffix,
+}
+
+func ValidateConflictPolicy(field string, policy ConflictPolicy) error {
+	return ValidateOneOf(field, policy, conflictPolicies)
+}
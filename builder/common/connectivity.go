@@ -0,0 +1,23 @@
+package common
+
+// ConnectivityMode defines how the communicator reaches the guest
+type ConnectivityMode string
+
+const (
+	// ConnectivityModePortForward tunnels the guest ports to the local host running Packer
+	ConnectivityModePortForward ConnectivityMode = "port_forward"
+	// ConnectivityModePodIP connects to the IP of the virt-launcher pod, Packer has to run inside the cluster
+	ConnectivityModePodIP ConnectivityMode = "pod_ip"
+	// ConnectivityModeService connects through a ClusterIP Service selecting the instance, Packer has to run inside the cluster
+	ConnectivityModeService ConnectivityMode = "service"
+)
+
+var connectivityModes = []ConnectivityMode{
+	ConnectivityModePortForward,
+	ConnectivityModePodIP,
+	ConnectivityModeService,
+}
+
+func ValidateConnectivityMode(field string, mode ConnectivityMode) error {
+	return ValidateOneOf(field, mode, connectivityModes)
+}
@@ -1,12 +1,23 @@
 package common
 
 import (
-	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
-	"log"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
+const (
+	SSHPortGeneratedDataKey   = "SSHPort"
+	WinRMPortGeneratedDataKey = "WinRMPort"
+)
+
+// LocalPortGeneratedDataKey names the generated data holding the local port of an extra port-forward, e.g. 'LocalPort3389'
+func LocalPortGeneratedDataKey(remotePort int) string {
+	return fmt.Sprintf("LocalPort%d", remotePort)
+}
+
 func GetEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -26,22 +37,29 @@ func IsReservedPort(value int) bool {
 	return value > 0 && value < 1024
 }
 
-func AskForRecreation(ui packersdk.Ui, deleteFunc func() error) error {
-	for {
-		line, err := ui.Ask("[r] recreate resource, [c] continue and let it fail")
-		if err != nil {
-			log.Printf("Error asking for input: %s", err)
-		}
-
-		input := strings.ToLower(line) + "c"
-		switch input[0] {
-		case 'r':
-			return deleteFunc()
-		case 'c':
-			return nil
-		default:
-			ui.Error("incorrect input, valid inputs: 'r', 'c'")
-			return AskForRecreation(ui, deleteFunc)
-		}
+// AllocateLocalPort asks the OS for a free port, so that parallel builds on the same host do not collide.
+// The listener is returned open, the port stays reserved until it is served or closed.
+func AllocateLocalPort() (net.Listener, int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(VirtualMachineHost, "0"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to allocate a local port: %w", err)
+	}
+	return listener, listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// ParsePortForward accepts 'remote' or 'local:remote', a missing or zero local port is allocated at build time
+func ParsePortForward(value string) (int, int, error) {
+	local, remote, found := strings.Cut(value, ":")
+	if !found {
+		local, remote = "0", local
+	}
+	localPort, err := strconv.Atoi(local)
+	if err != nil || localPort < 0 || localPort > 65535 {
+		return 0, 0, fmt.Errorf("invalid local port in %q", value)
+	}
+	remotePort, err := strconv.Atoi(remote)
+	if err != nil || remotePort <= 0 || remotePort > 65535 {
+		return 0, 0, fmt.Errorf("invalid remote port in %q", value)
 	}
+	return localPort, remotePort, nil
 }
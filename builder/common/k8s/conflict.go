@@ -0,0 +1,245 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	exportv1 "kubevirt.io/api/export/v1beta1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"packer-plugin-kubevirt/builder/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	ResourceDeletionTimeout = 5 * time.Minute
+	deletionPollInterval    = 2 * time.Second
+)
+
+// CleanupContext is used by the cleanups, the build context is already cancelled when the build is interrupted
+func CleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), ResourceDeletionTimeout)
+}
+
+// ResourceOperations abstracts the typed clients, so that conflicts are handled the same way for every kind of resource
+type ResourceOperations[T metav1.Object] struct {
+	Kind   string
+	Get    func(ctx context.Context, name string) (T, error)
+	Create func(ctx context.Context, obj T) (T, error)
+	Delete func(ctx context.Context, name string) error
+	// Disposable resources are recreated rather than reused, e.g. a completed Job holds the result of another run
+	Disposable bool
+}
+
+// CreateResource creates the resource and applies the conflict policy if a resource with the same name already exists.
+// The 'suffix' policy is resolved upfront on names, a conflict remaining at this point fails as with the 'fail' policy.
+func CreateResource[T metav1.Object](ctx context.Context, ops ResourceOperations[T], obj T, policy common.ConflictPolicy) (T, error) {
+	created, _, err := CreateOrReuseResource(ctx, ops, obj, policy)
+	return created, err
+}
+
+// CreateOrReuseResource is CreateResource telling whether an existing resource is reused, which the build must not delete
+func CreateOrReuseResource[T metav1.Object](ctx context.Context, ops ResourceOperations[T], obj T, policy common.ConflictPolicy) (T, bool, error) {
+	if policy == common.ConflictPolicyReuse && ops.Disposable {
+		policy = common.ConflictPolicyRecreate
+	}
+	if policy == common.ConflictPolicyRecreate {
+		err := DeleteResourceAndWait(ctx, ops, obj.GetNamespace(), obj.GetName(), ResourceDeletionTimeout)
+		if err != nil {
+			return obj, false, err
+		}
+	}
+
+	created, err := ops.Create(ctx, obj)
+	if err == nil || !k8serrors.IsAlreadyExists(err) {
+		return created, false, err
+	}
+
+	if policy == common.ConflictPolicyReuse {
+		existing, err := ops.Get(ctx, obj.GetName())
+		return existing, err == nil, err
+	}
+	return created, false, fmt.Errorf("%s %s/%s already exists, set 'conflict_policy' or run Packer with '-force' to replace it", ops.Kind, obj.GetNamespace(), obj.GetName())
+}
+
+// DeleteResourceAndWait deletes the resource along with its dependents and waits until it is gone
+func DeleteResourceAndWait[T metav1.Object](ctx context.Context, ops ResourceOperations[T], namespace, name string, timeout time.Duration) error {
+	err := ops.Delete(ctx, name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s %s: %w", ops.Kind, qualifiedName(namespace, name), err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, deletionPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := ops.Get(ctx, name)
+		if k8serrors.IsNotFound(err) {
+			return true, nil
+		}
+		// e.g. Forbidden, the resource would never be seen gone
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for %s %s to be deleted: %w", ops.Kind, qualifiedName(namespace, name), err)
+	}
+
+	return nil
+}
+
+func ResourceExists[T metav1.Object](ctx context.Context, ops ResourceOperations[T], name string) (bool, error) {
+	_, err := ops.Get(ctx, name)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+func foregroundDeletion() metav1.DeleteOptions {
+	propagationPolicy := metav1.DeletePropagationForeground
+	return metav1.DeleteOptions{PropagationPolicy: &propagationPolicy}
+}
+
+func VirtualMachineOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*kubevirtv1.VirtualMachine] {
+	return ResourceOperations[*kubevirtv1.VirtualMachine]{
+		Kind: "Virtual Machine",
+		Get: func(ctx context.Context, name string) (*kubevirtv1.VirtualMachine, error) {
+			return virtClient.VirtualMachine(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *kubevirtv1.VirtualMachine) (*kubevirtv1.VirtualMachine, error) {
+			return virtClient.VirtualMachine(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.VirtualMachine(namespace).Delete(ctx, name, foregroundDeletion())
+		},
+	}
+}
+
+func VirtualMachineExportOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*exportv1.VirtualMachineExport] {
+	return ResourceOperations[*exportv1.VirtualMachineExport]{
+		Kind: "Virtual Machine Export",
+		Get: func(ctx context.Context, name string) (*exportv1.VirtualMachineExport, error) {
+			return virtClient.VirtualMachineExport(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *exportv1.VirtualMachineExport) (*exportv1.VirtualMachineExport, error) {
+			return virtClient.VirtualMachineExport(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.VirtualMachineExport(namespace).Delete(ctx, name, foregroundDeletion())
+		},
+	}
+}
+
+func SecretOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*corev1.Secret] {
+	return ResourceOperations[*corev1.Secret]{
+		Kind: "Secret",
+		Get: func(ctx context.Context, name string) (*corev1.Secret, error) {
+			return virtClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *corev1.Secret) (*corev1.Secret, error) {
+			return virtClient.CoreV1().Secrets(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}
+}
+
+func ServiceOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*corev1.Service] {
+	return ResourceOperations[*corev1.Service]{
+		Kind: "Service",
+		Get: func(ctx context.Context, name string) (*corev1.Service, error) {
+			return virtClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *corev1.Service) (*corev1.Service, error) {
+			return virtClient.CoreV1().Services(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}
+}
+
+func PersistentVolumeClaimOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*corev1.PersistentVolumeClaim] {
+	return ResourceOperations[*corev1.PersistentVolumeClaim]{
+		Kind: "Persistent Volume Claim",
+		Get: func(ctx context.Context, name string) (*corev1.PersistentVolumeClaim, error) {
+			return virtClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+			return virtClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, foregroundDeletion())
+		},
+	}
+}
+
+func JobOperations(virtClient kubecli.KubevirtClient, namespace string) ResourceOperations[*batchv1.Job] {
+	return ResourceOperations[*batchv1.Job]{
+		Kind: "Job",
+		Get: func(ctx context.Context, name string) (*batchv1.Job, error) {
+			return virtClient.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *batchv1.Job) (*batchv1.Job, error) {
+			return virtClient.BatchV1().Jobs(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.BatchV1().Jobs(namespace).Delete(ctx, name, foregroundDeletion())
+		},
+		Disposable: true,
+	}
+}
+
+// DataVolumeOperations relies on the controller-runtime client, its scheme has to register the CDI types
+func DataVolumeOperations(kubeClient client.Client, namespace string) ResourceOperations[*cdiv1beta1.DataVolume] {
+	return ResourceOperations[*cdiv1beta1.DataVolume]{
+		Kind: "Data Volume",
+		Get: func(ctx context.Context, name string) (*cdiv1beta1.DataVolume, error) {
+			dataVolume := &cdiv1beta1.DataVolume{}
+			err := kubeClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, dataVolume)
+			return dataVolume, err
+		},
+		Create: func(ctx context.Context, obj *cdiv1beta1.DataVolume) (*cdiv1beta1.DataVolume, error) {
+			err := kubeClient.Create(ctx, obj)
+			return obj, err
+		},
+		Delete: func(ctx context.Context, name string) error {
+			dataVolume := &cdiv1beta1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+			return kubeClient.Delete(ctx, dataVolume, client.PropagationPolicy(metav1.DeletePropagationForeground))
+		},
+	}
+}
+
+// NamespaceOperations ignores the namespace of the resource, namespaces are cluster-scoped
+func NamespaceOperations(virtClient kubecli.KubevirtClient) ResourceOperations[*corev1.Namespace] {
+	return ResourceOperations[*corev1.Namespace]{
+		Kind: "Namespace",
+		Get: func(ctx context.Context, name string) (*corev1.Namespace, error) {
+			return virtClient.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *corev1.Namespace) (*corev1.Namespace, error) {
+			return virtClient.CoreV1().Namespaces().Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return virtClient.CoreV1().Namespaces().Delete(ctx, name, foregroundDeletion())
+		},
+	}
+}
@@ -0,0 +1,123 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"packer-plugin-kubevirt/builder/common"
+)
+
+func fakeSecretOperations(client kubernetes.Interface, namespace string) ResourceOperations[*corev1.Secret] {
+	return ResourceOperations[*corev1.Secret]{
+		Kind: "Secret",
+		Get: func(ctx context.Context, name string) (*corev1.Secret, error) {
+			return client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *corev1.Secret) (*corev1.Secret, error) {
+			return client.CoreV1().Secrets(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return client.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}
+}
+
+func fakeJobOperations(client kubernetes.Interface, namespace string) ResourceOperations[*batchv1.Job] {
+	return ResourceOperations[*batchv1.Job]{
+		Kind: "Job",
+		Get: func(ctx context.Context, name string) (*batchv1.Job, error) {
+			return client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj *batchv1.Job) (*batchv1.Job, error) {
+			return client.BatchV1().Jobs(namespace).Create(ctx, obj, metav1.CreateOptions{})
+		},
+		Delete: func(ctx context.Context, name string) error {
+			return client.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+		Disposable: true,
+	}
+}
+
+func TestCreateOrReuseResourceReportsReuse(t *testing.T) {
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-startup-script", Namespace: "packer"},
+		StringData: map[string]string{"userdata": "existing"},
+	}
+	client := fake.NewSimpleClientset(existing)
+	ops := fakeSecretOperations(client, "packer")
+
+	secret, reused, err := CreateOrReuseResource(context.TODO(), ops, existing.DeepCopy(), common.ConflictPolicyReuse)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reused || secret.StringData["userdata"] != "existing" {
+		t.Fatalf("expected the existing secret to be reused, got reused=%t %v", reused, secret.StringData)
+	}
+
+	created, reused, err := CreateOrReuseResource(context.TODO(), ops, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-user-credentials", Namespace: "packer"},
+	}, common.ConflictPolicyReuse)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused || created.Name != "ubuntu-user-credentials" {
+		t.Fatalf("expected the secret to be created, got reused=%t %s", reused, created.Name)
+	}
+}
+
+func TestCreateOrReuseResourceRecreatesDisposable(t *testing.T) {
+	completed := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-qemu-img", Namespace: "packer"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+		},
+	}
+	client := fake.NewSimpleClientset(completed)
+
+	job, reused, err := CreateOrReuseResource(context.TODO(), fakeJobOperations(client, "packer"), &batchv1.Job{
+		ObjectMeta: completed.ObjectMeta,
+	}, common.ConflictPolicyReuse)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused || len(job.Status.Conditions) != 0 {
+		t.Fatalf("expected the completed job to be recreated, got reused=%t %v", reused, job.Status.Conditions)
+	}
+}
+
+func TestCreateResourceFailsOnConflict(t *testing.T) {
+	existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-startup-script", Namespace: "packer"}}
+	client := fake.NewSimpleClientset(existing)
+
+	_, err := CreateResource(context.TODO(), fakeSecretOperations(client, "packer"), existing.DeepCopy(), common.ConflictPolicyFail)
+	if err == nil {
+		t.Fatal("expected an error for the existing secret")
+	}
+}
+
+func TestDeleteResourceAndWaitFailsOnGetError(t *testing.T) {
+	existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-startup-script", Namespace: "packer"}}
+	client := fake.NewSimpleClientset(existing)
+	client.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewForbidden(corev1.Resource("secrets"), existing.Name, errors.New("no get permission"))
+	})
+
+	start := time.Now()
+	err := DeleteResourceAndWait(context.TODO(), fakeSecretOperations(client, "packer"), "packer", existing.Name, time.Minute)
+	if err == nil || !k8serrors.IsForbidden(errors.Unwrap(err)) {
+		t.Fatalf("expected the forbidden error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the error to end the wait promptly, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,174 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	"time"
+)
+
+// serialConsoleCapture bounds the time spent reading the serial console, it only holds what the guest prints meanwhile
+const serialConsoleCapture = 5 * time.Second
+
+// Diagnostics collects the state of the build resources before they are deleted, so that a failed build can be
+// investigated afterward. Every item is best effort, a missing resource is only recorded in 'errors.txt'.
+type Diagnostics struct {
+	VirtClient     kubecli.KubevirtClient
+	KubeClient     client.Client
+	Namespace      string
+	VirtualMachine string
+	DataVolumes    []string
+	// PersistentVolumeClaims are collected along with the claims of the Data Volumes
+	PersistentVolumeClaims []string
+	Jobs                   []string
+	Export                 string
+
+	dir  string
+	errs []error
+}
+
+// Collect writes the bundle into a new timestamped directory under the given one, and returns its path
+func (d *Diagnostics) Collect(ctx context.Context, parentDir string) (string, error) {
+	d.dir = filepath.Join(parentDir, fmt.Sprintf("%s-%s", d.VirtualMachine, time.Now().Format("20060102-150405")))
+	err := os.MkdirAll(filepath.Join(d.dir, "logs"), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory %s: %w", d.dir, err)
+	}
+
+	d.collectResources(ctx)
+	d.collectEventsAndLogs(ctx)
+	d.collectConsole(ctx)
+
+	if len(d.errs) > 0 {
+		d.write("errors.txt", []byte(errors.Join(d.errs...).Error()+"\n"))
+	}
+	return d.dir, nil
+}
+
+func (d *Diagnostics) collectResources(ctx context.Context) {
+	vm, err := d.VirtClient.VirtualMachine(d.Namespace).Get(ctx, d.VirtualMachine, metav1.GetOptions{})
+	d.writeYAML("virtualmachine.yaml", vm, err)
+	vmi, err := d.VirtClient.VirtualMachineInstance(d.Namespace).Get(ctx, d.VirtualMachine, metav1.GetOptions{})
+	d.writeYAML("virtualmachineinstance.yaml", vmi, err)
+
+	for _, name := range d.DataVolumes {
+		dataVolume := &cdiv1beta1.DataVolume{}
+		err := d.KubeClient.Get(ctx, client.ObjectKey{Namespace: d.Namespace, Name: name}, dataVolume)
+		d.writeYAML(fmt.Sprintf("datavolume-%s.yaml", name), dataVolume, err)
+		// The claim of a Data Volume has the same name
+		claim, err := d.VirtClient.CoreV1().PersistentVolumeClaims(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+		d.writeYAML(fmt.Sprintf("persistentvolumeclaim-%s.yaml", name), claim, err)
+	}
+	for _, name := range d.PersistentVolumeClaims {
+		claim, err := d.VirtClient.CoreV1().PersistentVolumeClaims(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+		d.writeYAML(fmt.Sprintf("persistentvolumeclaim-%s.yaml", name), claim, err)
+	}
+	for _, name := range d.Jobs {
+		job, err := d.VirtClient.BatchV1().Jobs(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+		d.writeYAML(fmt.Sprintf("job-%s.yaml", name), job, err)
+	}
+	if d.Export != "" {
+		export, err := d.VirtClient.VirtualMachineExport(d.Namespace).Get(ctx, d.Export, metav1.GetOptions{})
+		d.writeYAML("virtualmachineexport.yaml", export, err)
+	}
+}
+
+// collectEventsAndLogs relies on the naming of the event streamer, the pods of the build are named after the VM
+func (d *Diagnostics) collectEventsAndLogs(ctx context.Context) {
+	matcher := EventStreamer{Names: []string{d.VirtualMachine}}
+
+	events, err := d.VirtClient.CoreV1().Events(d.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to list events: %w", err))
+	} else {
+		related := &corev1.EventList{}
+		for _, event := range events.Items {
+			if matcher.Involves(event.InvolvedObject) {
+				related.Items = append(related.Items, event)
+			}
+		}
+		d.writeYAML("events.yaml", related, nil)
+	}
+
+	pods, err := d.VirtClient.CoreV1().Pods(d.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to list pods: %w", err))
+		return
+	}
+	for _, pod := range pods.Items {
+		if !matcher.Involves(corev1.ObjectReference{Name: pod.Name}) {
+			continue
+		}
+		d.writeYAML(fmt.Sprintf("pod-%s.yaml", pod.Name), &pod, nil)
+		// The 'guest-console-log' container of the launcher pod holds the serial console output since boot
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			logs, err := d.VirtClient.CoreV1().Pods(d.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container.Name}).DoRaw(ctx)
+			if err != nil {
+				d.errs = append(d.errs, fmt.Errorf("failed to get logs of container %s of pod %s: %w", container.Name, pod.Name, err))
+				continue
+			}
+			d.write(filepath.Join("logs", fmt.Sprintf("%s_%s.log", pod.Name, container.Name)), logs)
+		}
+	}
+}
+
+// collectConsole captures what the guest prints on the serial console for a few seconds, and a VNC screenshot
+func (d *Diagnostics) collectConsole(ctx context.Context) {
+	vmi, err := d.VirtClient.VirtualMachineInstance(d.Namespace).Get(ctx, d.VirtualMachine, metav1.GetOptions{})
+	if err != nil || vmi.Status.Phase != kubevirtv1.Running {
+		return
+	}
+
+	screenshot, err := d.VirtClient.VirtualMachineInstance(d.Namespace).Screenshot(ctx, d.VirtualMachine, &kubevirtv1.ScreenshotOptions{})
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to take a VNC screenshot: %w", err))
+	} else {
+		d.write("screenshot.png", screenshot)
+	}
+
+	stream, err := d.VirtClient.VirtualMachineInstance(d.Namespace).SerialConsole(d.VirtualMachine, &kubecli.SerialConsoleOptions{ConnectionTimeout: serialConsoleCapture})
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to open the serial console: %w", err))
+		return
+	}
+	conn := stream.AsConn()
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(serialConsoleCapture))
+	output, _ := io.ReadAll(conn)
+	d.write("serial-console.log", output)
+}
+
+// writeYAML drops the managed fields, they make the resources harder to read without helping the investigation
+func (d *Diagnostics) writeYAML(filename string, obj any, getErr error) {
+	if getErr != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to get %s: %w", filename, getErr))
+		return
+	}
+	if object, ok := obj.(metav1.Object); ok {
+		object.SetManagedFields(nil)
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to marshal %s: %w", filename, err))
+		return
+	}
+	d.write(filename, data)
+}
+
+func (d *Diagnostics) write(filename string, data []byte) {
+	err := os.WriteFile(filepath.Join(d.dir, filename), data, 0o644)
+	if err != nil {
+		d.errs = append(d.errs, fmt.Errorf("failed to write %s: %w", filename, err))
+	}
+}
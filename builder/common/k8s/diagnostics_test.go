@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+)
+
+func TestDiagnosticsCollect(t *testing.T) {
+	virtClient := fake.NewVirtClient(
+		&kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"}},
+		&kubevirtv1.VirtualMachineInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+			Status:     kubevirtv1.VirtualMachineInstanceStatus{Phase: kubevirtv1.Running},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "virt-launcher-ubuntu-x7b2k", Namespace: "packer"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "compute"}}},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "virt-launcher-debian-p4m9s", Namespace: "packer"}},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "ubuntu.17f3a", Namespace: "packer"},
+			InvolvedObject: corev1.ObjectReference{Name: "ubuntu-source"},
+			Message:        "Import Successful",
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "debian.17f3b", Namespace: "packer"},
+			InvolvedObject: corev1.ObjectReference{Name: "debian-source"},
+			Message:        "Import Failed",
+		},
+	)
+	kubeClient := fake.NewKubeClient(&cdiv1beta1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-source", Namespace: "packer"}})
+	diagnostics := Diagnostics{
+		VirtClient:     virtClient,
+		KubeClient:     kubeClient,
+		Namespace:      "packer",
+		VirtualMachine: "ubuntu",
+		DataVolumes:    []string{"ubuntu-source"},
+		Jobs:           []string{"ubuntu-qemu-img"},
+	}
+
+	dir, err := diagnostics.Collect(context.TODO(), t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, filename := range []string{
+		"virtualmachine.yaml",
+		"virtualmachineinstance.yaml",
+		"datavolume-ubuntu-source.yaml",
+		"pod-virt-launcher-ubuntu-x7b2k.yaml",
+		filepath.Join("logs", "virt-launcher-ubuntu-x7b2k_compute.log"),
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filename)); err != nil {
+			t.Errorf("expected %s in the bundle: %v", filename, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pod-virt-launcher-debian-p4m9s.yaml")); err == nil {
+		t.Error("expected the pod of another VM to be left out of the bundle")
+	}
+
+	events, err := os.ReadFile(filepath.Join(dir, "events.yaml"))
+	if err != nil {
+		t.Fatalf("expected the events in the bundle: %v", err)
+	}
+	if !strings.Contains(string(events), "Import Successful") || strings.Contains(string(events), "Import Failed") {
+		t.Fatalf("expected only the events of the build resources, got:\n%s", events)
+	}
+
+	// Missing resources and the unavailable console are recorded, the rest of the bundle is still written
+	errs, err := os.ReadFile(filepath.Join(dir, "errors.txt"))
+	if err != nil {
+		t.Fatalf("expected the errors in the bundle: %v", err)
+	}
+	for _, expected := range []string{"persistentvolumeclaim-ubuntu-source.yaml", "job-ubuntu-qemu-img.yaml", "screenshot"} {
+		if !strings.Contains(string(errs), expected) {
+			t.Errorf("expected %q in the errors, got:\n%s", expected, errs)
+		}
+	}
+}
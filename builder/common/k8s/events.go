@@ -0,0 +1,140 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"strings"
+	"time"
+)
+
+const eventsRetryInterval = 5 * time.Second
+
+// EventStreamer forwards the Warning events of the build resources to the UI, e.g. PVC binding errors, failed mounts
+// or quota rejections, which would otherwise only be found with 'kubectl get events'.
+type EventStreamer struct {
+	Client    kubernetes.Interface
+	Ui        packersdk.Ui
+	Namespace string
+	// Names match the involved objects exactly or as the prefix of a derived name, e.g. 'ubuntu' matches the
+	// 'ubuntu-source' Data Volume and the 'virt-launcher-ubuntu-x7b2k' pod
+	Names []string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	seen   map[string]struct{}
+}
+
+// Start only forwards the events emitted from now on, the events of a previous build are left out
+func (e *EventStreamer) Start(ctx context.Context) error {
+	resourceVersion, err := e.currentResourceVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	e.seen = make(map[string]struct{})
+	go func() {
+		defer close(e.done)
+		e.stream(ctx, resourceVersion)
+	}()
+
+	return nil
+}
+
+func (e *EventStreamer) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+}
+
+// Involves reports whether the event is about one of the build resources
+func (e *EventStreamer) Involves(object corev1.ObjectReference) bool {
+	for _, name := range e.Names {
+		for _, prefix := range []string{"", "virt-launcher-", "importer-"} {
+			if object.Name == prefix+name || strings.HasPrefix(object.Name, prefix+name+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *EventStreamer) currentResourceVersion(ctx context.Context) (string, error) {
+	events, err := e.Client.CoreV1().Events(e.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", fmt.Errorf("failed to list events in %s: %w", e.Namespace, err)
+	}
+	return events.ResourceVersion, nil
+}
+
+// stream watches again from the last event received when the watch is closed by the API server
+func (e *EventStreamer) stream(ctx context.Context, resourceVersion string) {
+	for {
+		watcher, err := e.Client.CoreV1().Events(e.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("type", corev1.EventTypeWarning).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err == nil {
+			resourceVersion = e.consume(watcher, resourceVersion)
+		} else {
+			log.Printf("failed to watch events in %s, retrying in %s: %v", e.Namespace, eventsRetryInterval, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsRetryInterval):
+		}
+		if resourceVersion == "" {
+			// The resource version has expired, the events missed in the meantime are lost
+			resourceVersion, _ = e.currentResourceVersion(ctx)
+		}
+	}
+}
+
+// consume returns the resource version to resume from, or nothing when it has expired
+func (e *EventStreamer) consume(watcher watch.Interface, resourceVersion string) string {
+	defer watcher.Stop()
+
+	for event := range watcher.ResultChan() {
+		switch event.Type {
+		case watch.Error:
+			if status, ok := event.Object.(*metav1.Status); ok && status.Code == 410 {
+				return ""
+			}
+			log.Printf("error while watching events in %s: %v", e.Namespace, k8serrors.FromObject(event.Object))
+		case watch.Added, watch.Modified:
+			if kubeEvent, ok := event.Object.(*corev1.Event); ok {
+				resourceVersion = kubeEvent.ResourceVersion
+				e.forward(kubeEvent)
+			}
+		}
+	}
+	return resourceVersion
+}
+
+// forward prints every distinct warning once, a failing mount is otherwise repeated every few seconds
+func (e *EventStreamer) forward(event *corev1.Event) {
+	if !e.Involves(event.InvolvedObject) {
+		return
+	}
+	key := strings.Join([]string{event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, event.Message}, "/")
+	if _, seen := e.seen[key]; seen {
+		return
+	}
+	e.seen[key] = struct{}{}
+
+	e.Ui.Message(fmt.Sprintf("warning on %s %s/%s: %s: %s", event.InvolvedObject.Kind, e.Namespace, event.InvolvedObject.Name, event.Reason, event.Message))
+}
@@ -0,0 +1,26 @@
+package k8s
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestEventStreamerInvolves(t *testing.T) {
+	streamer := EventStreamer{Names: []string{"ubuntu"}}
+	testCases := map[string]bool{
+		"ubuntu":                     true,
+		"ubuntu-source":              true,
+		"ubuntu-3f2a9c-guestfs":      true,
+		"virt-launcher-ubuntu-x7b2k": true,
+		"importer-ubuntu-source":     true,
+		"ubuntu2":                    false,
+		"virt-launcher-debian-x7b2k": false,
+	}
+
+	for name, expected := range testCases {
+		if involved := streamer.Involves(corev1.ObjectReference{Name: name}); involved != expected {
+			t.Errorf("expected %s to be involved: %t, got: %t", name, expected, involved)
+		}
+	}
+}
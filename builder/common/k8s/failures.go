@@ -0,0 +1,178 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const (
+	// importerPodAnnotation is set by CDI on the claim of a Data Volume, the importer pod name is not predictable with populators
+	importerPodAnnotation = "cdi.kubevirt.io/storage.import.importPodName"
+	// UnschedulableGracePeriod leaves room for a cluster autoscaler to add a node before the pod is reported as unschedulable
+	UnschedulableGracePeriod = 2 * time.Minute
+)
+
+// imagePullFailures are the waiting reasons of a container whose image cannot be pulled, e.g. a wrong registry
+var imagePullFailures = []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName"}
+
+// DeploymentFailureDetector looks for terminal states that the VM conditions do not report, e.g. a bad 'source_url'
+// leaves the VM provisioning until the deployment timeout while the importer pod keeps crashing.
+type DeploymentFailureDetector struct {
+	VirtClient     kubecli.KubevirtClient
+	KubeClient     client.Client
+	Namespace      string
+	VirtualMachine string
+	DataVolumes    []string
+}
+
+// Check returns the root cause of the first terminal state found, errors reading the resources are not failures
+func (d *DeploymentFailureDetector) Check(ctx context.Context) error {
+	for _, name := range d.DataVolumes {
+		if err := d.checkDataVolume(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	vmi, err := d.VirtClient.VirtualMachineInstance(d.Namespace).Get(ctx, d.VirtualMachine, metav1.GetOptions{})
+	if err == nil && vmi.Status.Phase == kubevirtv1.Failed {
+		return fmt.Errorf("Virtual Machine Instance %s/%s has failed%s", d.Namespace, d.VirtualMachine, vmiConditionMessages(vmi))
+	}
+
+	pods, err := d.VirtClient.CoreV1().Pods(d.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			kubevirtv1.VirtualMachineNameLabel: d.VirtualMachine,
+		}).String(),
+	})
+	if err != nil {
+		return nil
+	}
+	for _, pod := range pods.Items {
+		if err := PodFailure(pod, time.Now()); err != nil {
+			return fmt.Errorf("launcher pod of Virtual Machine %s/%s cannot start: %w", d.Namespace, d.VirtualMachine, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *DeploymentFailureDetector) checkDataVolume(ctx context.Context, name string) error {
+	dataVolume := &cdiv1beta1.DataVolume{}
+	err := d.KubeClient.Get(ctx, client.ObjectKey{Namespace: d.Namespace, Name: name}, dataVolume)
+	if err != nil {
+		// The Data Volumes are created by KubeVirt from the VM templates, shortly after the VM
+		return nil
+	}
+
+	var importerPod *corev1.Pod
+	claim, err := d.VirtClient.CoreV1().PersistentVolumeClaims(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil && claim.Annotations[importerPodAnnotation] != "" {
+		importerPod, err = d.VirtClient.CoreV1().Pods(d.Namespace).Get(ctx, claim.Annotations[importerPodAnnotation], metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			importerPod = nil
+		} else if err != nil {
+			return nil
+		}
+	}
+
+	if err := DataVolumeFailure(dataVolume); err != nil {
+		if importerPod != nil {
+			if message := terminationMessage(*importerPod); message != "" {
+				err = fmt.Errorf("%w: %s", err, message)
+			}
+		}
+		return fmt.Errorf("Data Volume %s/%s import has failed: %w", d.Namespace, name, err)
+	}
+	if importerPod != nil {
+		if err := PodFailure(*importerPod, time.Now()); err != nil {
+			return fmt.Errorf("importer pod of Data Volume %s/%s cannot complete: %w", d.Namespace, name, err)
+		}
+	}
+
+	return nil
+}
+
+// DataVolumeFailure reports the 'Failed' phase and the 'ImportFailed' reason of the 'Running' condition
+func DataVolumeFailure(dataVolume *cdiv1beta1.DataVolume) error {
+	if dataVolume.Status.Phase == cdiv1beta1.Failed {
+		return fmt.Errorf("phase '%s'", dataVolume.Status.Phase)
+	}
+	for _, condition := range dataVolume.Status.Conditions {
+		if condition.Type == cdiv1beta1.DataVolumeRunning && condition.Reason == "ImportFailed" {
+			return fmt.Errorf("%s: %s", condition.Reason, condition.Message)
+		}
+	}
+	return nil
+}
+
+// PodFailure reports pods that cannot make progress on their own: unschedulable past the grace period,
+// an image that cannot be pulled, or a container in 'CrashLoopBackOff'.
+func PodFailure(pod corev1.Pod, now time.Time) error {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded {
+		return nil
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable &&
+			now.Sub(condition.LastTransitionTime.Time) > UnschedulableGracePeriod {
+			return fmt.Errorf("pod %s is unschedulable: %s", pod.Name, condition.Message)
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		reason := status.State.Waiting.Reason
+		for _, imagePullFailure := range imagePullFailures {
+			if reason == imagePullFailure {
+				return fmt.Errorf("container %s of pod %s cannot pull image '%s': %s", status.Name, pod.Name, status.Image, status.State.Waiting.Message)
+			}
+		}
+		if reason == "CrashLoopBackOff" {
+			err := fmt.Errorf("container %s of pod %s is in 'CrashLoopBackOff' after %d restarts", status.Name, pod.Name, status.RestartCount)
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Message != "" {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(terminated.Message))
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// terminationMessage returns the message the importer writes to '/dev/termination-log', e.g. an HTTP 404 on the source
+func terminationMessage(pod corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && terminated.Message != "" {
+			return strings.TrimSpace(terminated.Message)
+		}
+		if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Message != "" {
+			return strings.TrimSpace(terminated.Message)
+		}
+	}
+	return ""
+}
+
+func vmiConditionMessages(vmi *kubevirtv1.VirtualMachineInstance) string {
+	var messages []string
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Message != "" {
+			messages = append(messages, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+		}
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(messages, ", ")
+}
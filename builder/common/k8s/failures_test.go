@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+func TestPodFailure(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		status   corev1.PodStatus
+		expected string
+	}{
+		"running": {
+			status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		"unschedulable within the grace period": {
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionFalse,
+				Reason:             corev1.PodReasonUnschedulable,
+				LastTransitionTime: metav1.NewTime(now.Add(-time.Minute)),
+			}}},
+		},
+		"unschedulable": {
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionFalse,
+				Reason:             corev1.PodReasonUnschedulable,
+				Message:            "0/3 nodes are available: 3 Insufficient devices.kubevirt.io/kvm",
+				LastTransitionTime: metav1.NewTime(now.Add(-UnschedulableGracePeriod - time.Minute)),
+			}}},
+			expected: "Insufficient devices.kubevirt.io/kvm",
+		},
+		"image pull failure": {
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "compute",
+				Image: "quay.io/kubevirt/virt-launcher:v0",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}},
+			}}},
+			expected: "cannot pull image 'quay.io/kubevirt/virt-launcher:v0'",
+		},
+		"crash loop": {
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "importer",
+				RestartCount:         3,
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "HTTP request errored: 404 Not Found"}},
+			}}},
+			expected: "404 Not Found",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}, Status: testCase.status}
+			err := PodFailure(pod, now)
+			if testCase.expected == "" {
+				if err != nil {
+					t.Fatalf("expected no failure, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+				t.Fatalf("expected a failure containing %q, got: %v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestDataVolumeFailure(t *testing.T) {
+	dataVolume := &cdiv1beta1.DataVolume{Status: cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportInProgress}}
+	if err := DataVolumeFailure(dataVolume); err != nil {
+		t.Fatalf("expected no failure, got: %v", err)
+	}
+
+	dataVolume.Status.Phase = cdiv1beta1.Failed
+	if err := DataVolumeFailure(dataVolume); err == nil {
+		t.Fatalf("expected a failure for phase '%s'", cdiv1beta1.Failed)
+	}
+}
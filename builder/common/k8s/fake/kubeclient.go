@@ -0,0 +1,26 @@
+package fake
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// NewKubeClient returns a controller-runtime client registering the same types as the builder, e.g. Data Volumes
+func NewKubeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	builders := []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		cdiv1beta1.AddToScheme,
+		instancetypev1beta1.AddToScheme,
+	}
+	for _, addToScheme := range builders {
+		if err := addToScheme(scheme); err != nil {
+			panic(err)
+		}
+	}
+	return crfake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
@@ -0,0 +1,315 @@
+// Package fake provides in-memory clients for the tests of the steps, in the manner of the client-go fake clientset.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	exportv1 "kubevirt.io/api/export/v1beta1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// unimplemented leaves the KubeVirt APIs that are not faked nil, so that a test relying on them fails loudly.
+// It is nested one level deeper than the clientset, whose Kubernetes APIs take precedence.
+type unimplemented struct {
+	kubecli.KubevirtClient
+}
+
+// VirtClient serves the Kubernetes APIs from a fake clientset and the Virtual Machines, their instances and exports
+// from memory. Label and field selectors are ignored when listing them.
+type VirtClient struct {
+	*k8sfake.Clientset
+	unimplemented
+
+	virtualMachines         *objectStore[*kubevirtv1.VirtualMachine]
+	virtualMachineInstances *objectStore[*kubevirtv1.VirtualMachineInstance]
+	virtualMachineExports   *objectStore[*exportv1.VirtualMachineExport]
+
+	mu    sync.Mutex
+	stops []string
+	// StopError is returned by the stop requests of Virtual Machines, e.g. a conflict for a final instance
+	StopError error
+}
+
+// NewVirtClient sorts the KubeVirt objects into the in-memory stores, the other objects go to the fake clientset
+func NewVirtClient(objects ...runtime.Object) *VirtClient {
+	c := &VirtClient{
+		virtualMachines:         newObjectStore[*kubevirtv1.VirtualMachine](kubevirtv1.Resource("virtualmachines")),
+		virtualMachineInstances: newObjectStore[*kubevirtv1.VirtualMachineInstance](kubevirtv1.Resource("virtualmachineinstances")),
+		virtualMachineExports:   newObjectStore[*exportv1.VirtualMachineExport](exportv1.SchemeGroupVersion.WithResource("virtualmachineexports").GroupResource()),
+	}
+	var others []runtime.Object
+	for _, object := range objects {
+		switch obj := object.(type) {
+		case *kubevirtv1.VirtualMachine:
+			c.virtualMachines.add(obj)
+		case *kubevirtv1.VirtualMachineInstance:
+			c.virtualMachineInstances.add(obj)
+		case *exportv1.VirtualMachineExport:
+			c.virtualMachineExports.add(obj)
+		default:
+			others = append(others, object)
+		}
+	}
+	c.Clientset = k8sfake.NewSimpleClientset(others...)
+	return c
+}
+
+// Stops returns the Virtual Machines that were requested to stop, as 'namespace/name'
+func (c *VirtClient) Stops() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.stops...)
+}
+
+func (c *VirtClient) VirtualMachine(namespace string) kubecli.VirtualMachineInterface {
+	return &virtualMachines{client: c, namespace: namespace}
+}
+
+func (c *VirtClient) VirtualMachineInstance(namespace string) kubecli.VirtualMachineInstanceInterface {
+	return &virtualMachineInstances{client: c, namespace: namespace}
+}
+
+func (c *VirtClient) VirtualMachineExport(namespace string) kubecli.VirtualMachineExportInterface {
+	return &virtualMachineExports{store: c.virtualMachineExports, namespace: namespace}
+}
+
+type virtualMachines struct {
+	kubecli.VirtualMachineInterface
+	client    *VirtClient
+	namespace string
+}
+
+func (v *virtualMachines) Get(_ context.Context, name string, _ metav1.GetOptions) (*kubevirtv1.VirtualMachine, error) {
+	return v.client.virtualMachines.get(v.namespace, name)
+}
+
+func (v *virtualMachines) Create(_ context.Context, vm *kubevirtv1.VirtualMachine, _ metav1.CreateOptions) (*kubevirtv1.VirtualMachine, error) {
+	return v.client.virtualMachines.create(v.namespace, vm)
+}
+
+func (v *virtualMachines) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return v.client.virtualMachines.delete(v.namespace, name)
+}
+
+func (v *virtualMachines) List(_ context.Context, _ metav1.ListOptions) (*kubevirtv1.VirtualMachineList, error) {
+	return &kubevirtv1.VirtualMachineList{Items: values(v.client.virtualMachines.list(v.namespace))}, nil
+}
+
+func (v *virtualMachines) Watch(_ context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+	return v.client.virtualMachines.watch(v.namespace), nil
+}
+
+func (v *virtualMachines) Update(_ context.Context, vm *kubevirtv1.VirtualMachine, _ metav1.UpdateOptions) (*kubevirtv1.VirtualMachine, error) {
+	return vm, v.client.virtualMachines.update(vm)
+}
+
+// Stop only records the request, tests update the instance themselves
+func (v *virtualMachines) Stop(_ context.Context, name string, _ *kubevirtv1.StopOptions) error {
+	if _, err := v.client.virtualMachines.get(v.namespace, name); err != nil {
+		return err
+	}
+	v.client.mu.Lock()
+	defer v.client.mu.Unlock()
+	v.client.stops = append(v.client.stops, fmt.Sprintf("%s/%s", v.namespace, name))
+	return v.client.StopError
+}
+
+type virtualMachineInstances struct {
+	kubecli.VirtualMachineInstanceInterface
+	client    *VirtClient
+	namespace string
+}
+
+func (v *virtualMachineInstances) Get(_ context.Context, name string, _ metav1.GetOptions) (*kubevirtv1.VirtualMachineInstance, error) {
+	return v.client.virtualMachineInstances.get(v.namespace, name)
+}
+
+func (v *virtualMachineInstances) Create(_ context.Context, vmi *kubevirtv1.VirtualMachineInstance, _ metav1.CreateOptions) (*kubevirtv1.VirtualMachineInstance, error) {
+	return v.client.virtualMachineInstances.create(v.namespace, vmi)
+}
+
+func (v *virtualMachineInstances) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return v.client.virtualMachineInstances.delete(v.namespace, name)
+}
+
+func (v *virtualMachineInstances) List(_ context.Context, _ metav1.ListOptions) (*kubevirtv1.VirtualMachineInstanceList, error) {
+	return &kubevirtv1.VirtualMachineInstanceList{Items: values(v.client.virtualMachineInstances.list(v.namespace))}, nil
+}
+
+func (v *virtualMachineInstances) Watch(_ context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+	return v.client.virtualMachineInstances.watch(v.namespace), nil
+}
+
+func (v *virtualMachineInstances) Screenshot(_ context.Context, _ string, _ *kubevirtv1.ScreenshotOptions) ([]byte, error) {
+	return nil, fmt.Errorf("VNC is not supported by the fake client")
+}
+
+func (v *virtualMachineInstances) SerialConsole(_ string, _ *kubecli.SerialConsoleOptions) (kubecli.StreamInterface, error) {
+	return nil, fmt.Errorf("the serial console is not supported by the fake client")
+}
+
+type virtualMachineExports struct {
+	kubecli.VirtualMachineExportInterface
+	store     *objectStore[*exportv1.VirtualMachineExport]
+	namespace string
+}
+
+func (v *virtualMachineExports) Get(_ context.Context, name string, _ metav1.GetOptions) (*exportv1.VirtualMachineExport, error) {
+	return v.store.get(v.namespace, name)
+}
+
+func (v *virtualMachineExports) Create(_ context.Context, export *exportv1.VirtualMachineExport, _ metav1.CreateOptions) (*exportv1.VirtualMachineExport, error) {
+	return v.store.create(v.namespace, export)
+}
+
+func (v *virtualMachineExports) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return v.store.delete(v.namespace, name)
+}
+
+func (v *virtualMachineExports) List(_ context.Context, _ metav1.ListOptions) (*exportv1.VirtualMachineExportList, error) {
+	return &exportv1.VirtualMachineExportList{Items: values(v.store.list(v.namespace))}, nil
+}
+
+func (v *virtualMachineExports) Watch(_ context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+	return v.store.watch(v.namespace), nil
+}
+
+func (v *virtualMachineExports) Update(_ context.Context, export *exportv1.VirtualMachineExport, _ metav1.UpdateOptions) (*exportv1.VirtualMachineExport, error) {
+	return export, v.store.update(export)
+}
+
+type copyable[T any] interface {
+	metav1.Object
+	runtime.Object
+	DeepCopy() T
+}
+
+// objectStore keeps copies of the objects, so that callers can't modify the stored state by mistake
+type objectStore[T copyable[T]] struct {
+	resource schema.GroupResource
+	mu       sync.Mutex
+	items    map[string]T
+	watchers []namespacedWatcher
+}
+
+type namespacedWatcher struct {
+	namespace string
+	watcher   *watch.RaceFreeFakeWatcher
+}
+
+func newObjectStore[T copyable[T]](resource schema.GroupResource) *objectStore[T] {
+	return &objectStore[T]{
+		resource: resource,
+		items:    make(map[string]T),
+	}
+}
+
+func (s *objectStore[T]) add(obj T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key(obj.GetNamespace(), obj.GetName())] = obj.DeepCopy()
+}
+
+func (s *objectStore[T]) get(namespace, name string) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.items[key(namespace, name)]
+	if !ok {
+		return obj, k8serrors.NewNotFound(s.resource, name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (s *objectStore[T]) create(namespace string, obj T) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[key(namespace, obj.GetName())]; ok {
+		var empty T
+		return empty, k8serrors.NewAlreadyExists(s.resource, obj.GetName())
+	}
+	created := obj.DeepCopy()
+	created.SetNamespace(namespace)
+	s.items[key(namespace, obj.GetName())] = created
+	s.notify(watch.Added, created)
+	return created.DeepCopy(), nil
+}
+
+// update replaces the object, as a controller updating its status would
+func (s *objectStore[T]) update(obj T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[key(obj.GetNamespace(), obj.GetName())]; !ok {
+		return k8serrors.NewNotFound(s.resource, obj.GetName())
+	}
+	s.items[key(obj.GetNamespace(), obj.GetName())] = obj.DeepCopy()
+	s.notify(watch.Modified, obj)
+	return nil
+}
+
+func (s *objectStore[T]) delete(namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.items[key(namespace, name)]
+	if !ok {
+		return k8serrors.NewNotFound(s.resource, name)
+	}
+	delete(s.items, key(namespace, name))
+	s.notify(watch.Deleted, obj)
+	return nil
+}
+
+// watch only sends the changes made after it started, as the API server does for a watch without resource version
+func (s *objectStore[T]) watch(namespace string) watch.Interface {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watcher := watch.NewRaceFreeFake()
+	s.watchers = append(s.watchers, namespacedWatcher{namespace: namespace, watcher: watcher})
+	return watcher
+}
+
+// notify expects the lock to be held
+func (s *objectStore[T]) notify(eventType watch.EventType, obj T) {
+	for _, w := range s.watchers {
+		if w.namespace == obj.GetNamespace() && !w.watcher.IsStopped() {
+			w.watcher.Action(eventType, obj.DeepCopy())
+		}
+	}
+}
+
+// list returns copies sorted by name, the list types of KubeVirt hold values that callers dereference
+func (s *objectStore[T]) list(namespace string) []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []T
+	for _, obj := range s.items {
+		if obj.GetNamespace() == namespace {
+			items = append(items, obj.DeepCopy())
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+	return items
+}
+
+func values[T any](items []*T) []T {
+	result := make([]T, 0, len(items))
+	for _, item := range items {
+		result = append(result, *item)
+	}
+	return result
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+var _ kubecli.KubevirtClient = &VirtClient{}
@@ -1,6 +1,8 @@
 package generator
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -8,7 +10,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
 	kubevirtv1 "kubevirt.io/api/core/v1"
+	"packer-plugin-kubevirt/builder/common"
 	"path"
+	"strings"
+	"text/template"
 )
 
 const (
@@ -18,14 +23,231 @@ const (
 	vmDiskPath        = "/disk"
 	tmpDirVolumeName  = "libguestfs-tmp-dir"
 	tmpDirPath        = "/tmp/guestfs"
+	secretVolumeName  = "root-password"
+	secretPath        = "/run/secrets/guestfs"
+	rootPasswordKey   = "password"
+
+	sourceDiskVolumeName    = "src-disk"
+	sourceDiskPath          = "/source"
+	convertedDiskVolumeName = "dst-disk"
+	convertedDiskPath       = "/converted"
 )
 
-func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string) *batchv1.Job {
+// sysprepLogsScript reads the files offline with 'virt-cat', UTF-16 content is printable once NUL bytes are dropped
+const sysprepLogsScript = `
+state=$(virt-cat -a /disk/disk.img 'c:\windows\setup\state\state.ini' | tr -d '\000\r')
+echo "$state"
+case "$state" in
+  *IMAGE_STATE_GENERALIZE_RESEAL_TO_OOBE*) exit 0 ;;
+esac
+echo "the image is not generalized, last lines of setupact.log:"
+virt-cat -a /disk/disk.img 'c:\windows\system32\sysprep\panther\setupact.log' | tr -d '\000\r' | tail -n 50
+echo "setuperr.log:"
+virt-cat -a /disk/disk.img 'c:\windows\system32\sysprep\panther\setuperr.log' | tr -d '\000\r'
+exit 1
+`
+
+// sparsifyScript reports the space allocated to the disk image, which is what the sparsification reduces
+const sparsifyScript = `
+set -e
+echo "disk allocation before sparsify: $(du -h disk.img | cut -f1) of $(du -h --apparent-size disk.img | cut -f1)"
+virt-sparsify --in-place disk.img
+echo "disk allocation after sparsify: $(du -h disk.img | cut -f1) of $(du -h --apparent-size disk.img | cut -f1)"
+`
+
+// qemuImgScript reports the size of the converted image, the export server serves the file as is
+const qemuImgScript = `
+set -e
+%s
+echo "converted image size: $(du -h %[2]s | cut -f1)"
+qemu-img info %[2]s
+`
+
+// ovaScript fills in the disk size in the descriptor, the manifest lets vSphere check the files of the package
+const ovaScript = `
+set -e
+mkdir -p /converted/ova
+cd /converted/ova
+%s
+capacity=$(stat -c %%s /source/disk.img)
+size=$(stat -c %%s "$OVA_DISK")
+printf '%%s\n' "$OVF_DESCRIPTOR" | sed "s/@@DISK_CAPACITY@@/$capacity/; s/@@DISK_SIZE@@/$size/" > "$OVA_DESCRIPTOR"
+for file in "$OVA_DESCRIPTOR" "$OVA_DISK"; do
+  echo "SHA256($file)= $(sha256sum "$file" | cut -d' ' -f1)"
+done > "$OVA_MANIFEST"
+tar --format=ustar -cf /converted/disk.img "$OVA_DESCRIPTOR" "$OVA_MANIFEST" "$OVA_DISK"
+cd /
+rm -rf /converted/ova
+echo "OVA size: $(du -h /converted/disk.img | cut -f1)"
+`
+
+// DefaultGuestFSImage provides 'virt-sysprep' along with a prebuilt appliance
+const DefaultGuestFSImage = "quay.io/kubevirt/libguestfs-tools:v1.2.0"
+
+// GeneralizeOptions are the 'virt-sysprep' settings, see virt-sysprep(1) for the operations and the password selectors
+type GeneralizeOptions struct {
+	Image string
+	// Operations are passed to '--operations', e.g. 'defaults' or 'machine-id', disabled ones are prefixed with '-'
+	Operations         []string
+	KeepUserAccounts   []string
+	RemoveUserAccounts []string
+	RunCommands        []string
+	FirstbootCommands  []string
+	// RootPassword is a '--root-password' selector, a clear password is mounted from a Secret rather than set in the Job
+	RootPassword string
+	Resources    corev1.ResourceRequirements
+}
+
+// rootPassword splits the clear password out of the selector, e.g. 'locked:password:xyz' becomes 'locked:file:<path>'
+func (o GeneralizeOptions) rootPassword() (selector string, password string) {
+	locked := strings.HasPrefix(o.RootPassword, "locked:")
+	selector = strings.TrimPrefix(o.RootPassword, "locked:")
+	if strings.HasPrefix(selector, "password:") {
+		password = strings.TrimPrefix(selector, "password:")
+		selector = "file:" + path.Join(secretPath, rootPasswordKey)
+	}
+	if locked {
+		selector = "locked:" + selector
+	}
+	return selector, password
+}
+
+func buildSysprepCommand(opts GeneralizeOptions) []string {
+	command := []string{
+		"virt-sysprep",
+		"--verbose",
+		"--add",
+		path.Join(vmDiskPath, "disk.img"),
+		"--network",
+	}
+	if len(opts.Operations) > 0 {
+		command = append(command, "--operations", strings.Join(opts.Operations, ","))
+	}
+	if len(opts.KeepUserAccounts) > 0 {
+		command = append(command, "--keep-user-accounts", strings.Join(opts.KeepUserAccounts, ","))
+	}
+	if len(opts.RemoveUserAccounts) > 0 {
+		command = append(command, "--remove-user-accounts", strings.Join(opts.RemoveUserAccounts, ","))
+	}
+	for _, runCommand := range opts.RunCommands {
+		command = append(command, "--run-command", runCommand)
+	}
+	for _, firstbootCommand := range opts.FirstbootCommands {
+		command = append(command, "--firstboot-command", firstbootCommand)
+	}
+	if opts.RootPassword != "" {
+		selector, _ := opts.rootPassword()
+		command = append(command, "--root-password", selector)
+	}
+	return command
+}
+
+// GenerateGeneralizeUnattend returns the answer file used by the Windows generalization, which removes the build user
+func GenerateGeneralizeUnattend(user string) (string, error) {
+	rawData, err := scripts.ReadFile(path.Join("scripts", "generalize-unattend.xml"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read generalize answer file: %s", err)
+	}
+	tmpl, err := template.New("unattend").Parse(string(rawData))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generalize answer file: %s", err)
+	}
+
+	var escapedUser bytes.Buffer
+	_ = xml.EscapeText(&escapedUser, []byte(user))
+	var unattend bytes.Buffer
+	err = tmpl.Execute(&unattend, struct{ User string }{User: escapedUser.String()})
+	if err != nil {
+		return "", fmt.Errorf("failed to render generalize answer file: %s", err)
+	}
+	return unattend.String(), nil
+}
+
+// GenerateRootPasswordSecret returns nothing unless a clear root password is set
+func GenerateRootPasswordSecret(vm *kubevirtv1.VirtualMachine, opts GeneralizeOptions) *corev1.Secret {
+	_, password := opts.rootPassword()
+	if password == "" {
+		return nil
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        buildSecretName(vm.Name, RootPasswordSuffix),
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: common.InheritAnnotations(vm.Annotations),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			rootPasswordKey: password,
+		},
+	}
+}
+
+type JobSuffix string
+
+const (
+	GuestFSJobSuffix     JobSuffix = "libguestfs"
+	SysprepLogsJobSuffix JobSuffix = "sysprep-logs"
+	SparsifyJobSuffix    JobSuffix = "sparsify"
+	QemuImgJobSuffix     JobSuffix = "qemu-img-conversion"
+)
+
+func buildJobName(vmName string, suffix JobSuffix) string {
+	return fmt.Sprintf("%s-%s", vmName, suffix)
+}
+
+func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string, opts GeneralizeOptions) *batchv1.Job {
+	job := generateLibguestfsJob(vm, pvcName, GuestFSJobSuffix, opts, buildSysprepCommand(opts))
+
+	if secret := GenerateRootPasswordSecret(vm, opts); secret != nil {
+		podSpec := &job.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: secretVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: secret.Name},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      secretVolumeName,
+			ReadOnly:  true,
+			MountPath: secretPath,
+		})
+	}
 
+	return job
+}
+
+// GenerateSysprepLogsJob fails unless the Windows image state is generalized, with the sysprep logs as last output
+func GenerateSysprepLogsJob(vm *kubevirtv1.VirtualMachine, pvcName string, opts GeneralizeOptions) *batchv1.Job {
+	return generateLibguestfsJob(vm, pvcName, SysprepLogsJobSuffix, opts, []string{"/bin/sh", "-c", sysprepLogsScript})
+}
+
+// GenerateSparsifyJob discards the unused blocks of the disk in place, with 'virt-sparsify --in-place'
+func GenerateSparsifyJob(vm *kubevirtv1.VirtualMachine, pvcName string, opts GeneralizeOptions) *batchv1.Job {
+	return generateLibguestfsJob(vm, pvcName, SparsifyJobSuffix, opts, []string{"/bin/sh", "-c", sparsifyScript})
+}
+
+// ZeroFreeSpaceScript returns the PowerShell script filling the free space of Windows guests with zeros
+func ZeroFreeSpaceScript() (string, error) {
+	rawData, err := scripts.ReadFile(path.Join("scripts", "zero-free-space.ps1"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read zero free space script: %s", err)
+	}
+	return string(rawData), nil
+}
+
+// generateLibguestfsJob mounts the disk for the libguestfs tools, the appliance runs in the container with KVM
+func generateLibguestfsJob(vm *kubevirtv1.VirtualMachine, pvcName string, suffix JobSuffix, opts GeneralizeOptions, command []string) *batchv1.Job {
 	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-libguestfs", vm.Name),
-			Namespace: vm.Namespace,
+			Name:        buildJobName(vm.Name, suffix),
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: common.InheritAnnotations(vm.Annotations),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
 			},
@@ -33,6 +255,9 @@ func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string) *batchv1.
 		Spec: batchv1.JobSpec{
 			TTLSecondsAfterFinished: pointer.Int32(30),
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: common.InheritLabels(vm.Labels),
+				},
 				Spec: corev1.PodSpec{
 					NodeSelector:  vm.Spec.Template.Spec.NodeSelector,
 					Tolerations:   vm.Spec.Template.Spec.Tolerations,
@@ -48,21 +273,9 @@ func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string) *batchv1.
 					},
 					Containers: []corev1.Container{
 						{
-							Name:  "libguestfs",
-							Image: "quay.io/kubevirt/libguestfs-tools:v1.2.0",
-							Command: []string{
-								"virt-sysprep",
-								"--verbose",
-								"--add",
-								path.Join(vmDiskPath, "disk.img"),
-								//"--run-command",
-								//"'cloud-init clean'",
-								"--network",
-								"--enable",
-								"bash-history,machine-id,user-account",
-								"--keep-user-accounts",
-								"packer",
-							},
+							Name:       "libguestfs",
+							Image:      opts.Image,
+							Command:    command,
 							WorkingDir: vmDiskPath,
 							// LIBGUESTFS_BACKEND  -> use directly host qemu
 							// LIBGUESTFS_PATH 	   -> path to root, initrd and the kernel are located
@@ -112,11 +325,7 @@ func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string) *batchv1.
 								},
 							},
 							ImagePullPolicy: corev1.PullIfNotPresent,
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									"devices.kubevirt.io/kvm": resource.MustParse("1"),
-								},
-							},
+							Resources:       guestFSResources(opts.Resources),
 						},
 					},
 					Volumes: []corev1.Volume{
@@ -148,11 +357,92 @@ func GenerateGuestFSJob(vm *kubevirtv1.VirtualMachine, pvcName string) *batchv1.
 	}
 }
 
-func GenerateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVCName string) *batchv1.Job {
+// guestFSResources adds the KVM device to the configured resources, the appliance is too slow without acceleration
+func guestFSResources(resources corev1.ResourceRequirements) corev1.ResourceRequirements {
+	resources = *resources.DeepCopy()
+	if resources.Limits == nil {
+		resources.Limits = corev1.ResourceList{}
+	}
+	resources.Limits["devices.kubevirt.io/kvm"] = resource.MustParse("1")
+	return resources
+}
+
+// OutputFormat is the format of the converted image, each one sets the options expected by the platforms consuming it
+type OutputFormat string
+
+const (
+	OutputFormatQcow2 OutputFormat = "qcow2"
+	OutputFormatVmdk  OutputFormat = "vmdk"
+	OutputFormatVhd   OutputFormat = "vhd"
+	OutputFormatVhdx  OutputFormat = "vhdx"
+	OutputFormatRaw   OutputFormat = "raw"
+	// OutputFormatOva packages a stream-optimized VMDK with an OVF descriptor for vSphere
+	OutputFormatOva OutputFormat = "ova"
+)
+
+var outputFormats = []OutputFormat{OutputFormatQcow2, OutputFormatVmdk, OutputFormatVhd, OutputFormatVhdx, OutputFormatRaw, OutputFormatOva}
+
+func ValidateOutputFormat(field string, format OutputFormat) error {
+	return common.ValidateOneOf(field, format, outputFormats)
+}
+
+// qemuImgOptions returns the '-O' format along with its options, e.g. a stream-optimized VMDK for vSphere imports
+func (f OutputFormat) qemuImgOptions() []string {
+	switch f {
+	case OutputFormatQcow2:
+		return []string{"-O", "qcow2", "-c"}
+	case OutputFormatVmdk, OutputFormatOva:
+		return []string{"-O", "vmdk", "-o", "subformat=streamOptimized"}
+	case OutputFormatVhd:
+		// The size of the image must not be rounded to the VHD geometry, Azure rejects it otherwise
+		return []string{"-O", "vpc", "-o", "subformat=dynamic,force_size=on"}
+	case OutputFormatVhdx:
+		return []string{"-O", "vhdx", "-o", "subformat=dynamic"}
+	default:
+		return []string{"-O", "raw"}
+	}
+}
+
+// FileExtension names the downloaded image, a raw image keeps the '.img' extension of the exported disks
+func (f OutputFormat) FileExtension() string {
+	if f == "" || f == OutputFormatRaw {
+		return "img"
+	}
+	return string(f)
+}
+
+// GenerateQemuImgJob converts the raw disk into the destination claim, the image is named 'disk.img' as the export expects
+func GenerateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVCName string, format OutputFormat, opts GeneralizeOptions) *batchv1.Job {
+	convert := append([]string{"qemu-img", "convert", "-f", "raw"}, format.qemuImgOptions()...)
+	convert = append(convert, path.Join(sourceDiskPath, "disk.img"), path.Join(convertedDiskPath, "disk.img"))
+	script := fmt.Sprintf(qemuImgScript, strings.Join(convert, " "), path.Join(convertedDiskPath, "disk.img"))
+
+	return generateQemuImgJob(vm, srcPVCName, dstPVCName, opts, script, nil)
+}
+
+// GenerateOvaJob converts the disk to a stream-optimized VMDK and archives it with the descriptor as 'disk.img',
+// the files are passed by name through the environment as the image name is set by the user
+func GenerateOvaJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVCName string, descriptor string, ovf OvfOptions, opts GeneralizeOptions) *batchv1.Job {
+	descriptorFile, manifestFile, diskFile := ovf.OvaFiles()
+	convert := append([]string{"qemu-img", "convert", "-f", "raw"}, OutputFormatOva.qemuImgOptions()...)
+	convert = append(convert, path.Join(sourceDiskPath, "disk.img"), `"$OVA_DISK"`)
+	env := []corev1.EnvVar{
+		{Name: "OVF_DESCRIPTOR", Value: descriptor},
+		{Name: "OVA_DESCRIPTOR", Value: descriptorFile},
+		{Name: "OVA_MANIFEST", Value: manifestFile},
+		{Name: "OVA_DISK", Value: diskFile},
+	}
+
+	return generateQemuImgJob(vm, srcPVCName, dstPVCName, opts, fmt.Sprintf(ovaScript, strings.Join(convert, " ")), env)
+}
+
+func generateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVCName string, opts GeneralizeOptions, script string, env []corev1.EnvVar) *batchv1.Job {
 	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-qemu-img-conversion", vm.Name),
-			Namespace: vm.Namespace,
+			Name:        buildJobName(vm.Name, QemuImgJobSuffix),
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: common.InheritAnnotations(vm.Annotations),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
 			},
@@ -160,6 +450,9 @@ func GenerateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVC
 		Spec: batchv1.JobSpec{
 			TTLSecondsAfterFinished: pointer.Int32(30),
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: common.InheritLabels(vm.Labels),
+				},
 				Spec: corev1.PodSpec{
 					NodeSelector:  vm.Spec.Template.Spec.NodeSelector,
 					Tolerations:   vm.Spec.Template.Spec.Tolerations,
@@ -175,34 +468,36 @@ func GenerateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVC
 					},
 					Containers: []corev1.Container{
 						{
-							Name:  "qemu-img",
-							Image: "your-registry/qemu-img:latest",
-							Command: []string{
-								"qemu-img", "convert", "-f", "raw", "-O", "qcow2", path.Join("/disk", "input.img"), path.Join("/disk", "output.qcow2"),
+							Name:    "qemu-img",
+							Image:   opts.Image,
+							Command: []string{"/bin/sh", "-c", script},
+							Env:     env,
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: pointer.Bool(false),
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
-									Name:      "src-disk",
-									ReadOnly:  false,
-									MountPath: path.Join("/disk", "input.img"),
+									Name:      sourceDiskVolumeName,
+									ReadOnly:  true,
+									MountPath: sourceDiskPath,
 								},
 								{
-									Name:      "dst-disk",
+									Name:      convertedDiskVolumeName,
 									ReadOnly:  false,
-									MountPath: path.Join("/disk", "output.qcow2"),
+									MountPath: convertedDiskPath,
 								},
 							},
 							ImagePullPolicy: corev1.PullIfNotPresent,
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									"devices.kubevirt.io/kvm": resource.MustParse("1"),
-								},
-							},
+							// The conversion does not run the appliance, no KVM device is needed
+							Resources: opts.Resources,
 						},
 					},
 					Volumes: []corev1.Volume{
 						{
-							Name: "src-disk",
+							Name: sourceDiskVolumeName,
 							VolumeSource: corev1.VolumeSource{
 								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 									ClaimName: srcPVCName,
@@ -211,7 +506,7 @@ func GenerateQemuImgJob(vm *kubevirtv1.VirtualMachine, srcPVCName string, dstPVC
 							},
 						},
 						{
-							Name: "dst-disk",
+							Name: convertedDiskVolumeName,
 							VolumeSource: corev1.VolumeSource{
 								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 									ClaimName: dstPVCName,
@@ -1,7 +1,12 @@
 package generator
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
 )
 
 // Test used as an entrypoint to validate guestfs container
@@ -14,9 +19,147 @@ func TestJob(t *testing.T) {
 	//	},
 	//}
 	//
-	//job := GenerateGuestFSJob(&vm, vm.Name)
+	//job := GenerateGuestFSJob(&vm, vm.Name, GeneralizeOptions{Image: DefaultGuestFSImage})
 	//job, err := client.BatchV1().Jobs(vm.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
 	//assert.NoError(t, err)
-	//err = k8s.WaitForJobCompletion(client.BatchV1(), new(packersdk.MockUi), job, 30*time.Second)
+	//err = k8s.WaitForJobCompletion(context.TODO(), client, new(packersdk.MockUi), job, 30*time.Second)
 	//assert.NoError(t, err)
 }
+
+func TestBuildSysprepCommand(t *testing.T) {
+	command := buildSysprepCommand(GeneralizeOptions{
+		Operations:        []string{"defaults", "-ssh-hostkeys"},
+		KeepUserAccounts:  []string{"packer", "admin"},
+		RunCommands:       []string{"cloud-init clean --logs"},
+		FirstbootCommands: []string{"ssh-keygen -A"},
+		RootPassword:      "locked:password:secret",
+	})
+
+	expected := []string{
+		"virt-sysprep", "--verbose", "--add", "/disk/disk.img", "--network",
+		"--operations", "defaults,-ssh-hostkeys",
+		"--keep-user-accounts", "packer,admin",
+		"--run-command", "cloud-init clean --logs",
+		"--firstboot-command", "ssh-keygen -A",
+		"--root-password", "locked:file:/run/secrets/guestfs/password",
+	}
+	if !reflect.DeepEqual(command, expected) {
+		t.Fatalf("unexpected command:\n%v\nexpected:\n%v", command, expected)
+	}
+}
+
+func TestGenerateGuestFSJobRootPassword(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+
+	job := GenerateGuestFSJob(vm, "ubuntu-source", GeneralizeOptions{RootPassword: "random"})
+	if GenerateRootPasswordSecret(vm, GeneralizeOptions{RootPassword: "random"}) != nil || len(job.Spec.Template.Spec.Volumes) != 3 {
+		t.Fatalf("expected no root password Secret for the 'random' selector")
+	}
+
+	opts := GeneralizeOptions{RootPassword: "password:s3cr3t"}
+	secret := GenerateRootPasswordSecret(vm, opts)
+	if secret == nil || secret.StringData[rootPasswordKey] != "s3cr3t" {
+		t.Fatalf("expected the clear password in the Secret, got: %v", secret)
+	}
+	job = GenerateGuestFSJob(vm, "ubuntu-source", opts)
+	if strings.Contains(strings.Join(job.Spec.Template.Spec.Containers[0].Command, " "), "s3cr3t") {
+		t.Fatalf("the clear password must not be part of the Job: %v", job.Spec.Template.Spec.Containers[0].Command)
+	}
+	volumes := job.Spec.Template.Spec.Volumes
+	if last := volumes[len(volumes)-1]; last.Secret == nil || last.Secret.SecretName != secret.Name {
+		t.Fatalf("expected the Secret to be mounted, got: %v", volumes)
+	}
+}
+
+func TestGenerateGeneralizeUnattend(t *testing.T) {
+	unattend, err := GenerateGeneralizeUnattend("packer&co")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(unattend, `net user "packer&amp;co" /delete`) {
+		t.Fatalf("expected the escaped user to be removed, got: %s", unattend)
+	}
+}
+
+func TestGenerateSparsifyJob(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+
+	job := GenerateSparsifyJob(vm, "ubuntu-source", GeneralizeOptions{Image: DefaultGuestFSImage, RootPassword: "password:s3cr3t"})
+	if job.Name != buildJobName(vm.Name, SparsifyJobSuffix) {
+		t.Fatalf("unexpected Job name %s", job.Name)
+	}
+	command := strings.Join(job.Spec.Template.Spec.Containers[0].Command, " ")
+	if !strings.Contains(command, "virt-sparsify --in-place disk.img") {
+		t.Fatalf("expected an in-place sparsification, got: %s", command)
+	}
+	if len(job.Spec.Template.Spec.Volumes) != 3 {
+		t.Fatalf("the root password Secret must only be mounted by the generalization, got: %v", job.Spec.Template.Spec.Volumes)
+	}
+}
+
+func TestGenerateQemuImgJob(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+
+	testCases := map[OutputFormat]string{
+		OutputFormatQcow2: "qemu-img convert -f raw -O qcow2 -c /source/disk.img /converted/disk.img",
+		OutputFormatVmdk:  "qemu-img convert -f raw -O vmdk -o subformat=streamOptimized /source/disk.img /converted/disk.img",
+		OutputFormatVhd:   "qemu-img convert -f raw -O vpc -o subformat=dynamic,force_size=on /source/disk.img /converted/disk.img",
+		OutputFormatVhdx:  "qemu-img convert -f raw -O vhdx -o subformat=dynamic /source/disk.img /converted/disk.img",
+		OutputFormatRaw:   "qemu-img convert -f raw -O raw /source/disk.img /converted/disk.img",
+	}
+	for format, expected := range testCases {
+		job := GenerateQemuImgJob(vm, "ubuntu-source", "ubuntu-converted", format, GeneralizeOptions{Image: DefaultGuestFSImage})
+		container := job.Spec.Template.Spec.Containers[0]
+		if command := strings.Join(container.Command, " "); !strings.Contains(command, expected) {
+			t.Fatalf("expected %q for format %s, got: %s", expected, format, command)
+		}
+		if container.Image != DefaultGuestFSImage || len(container.Resources.Limits) != 0 {
+			t.Fatalf("expected the configured image without the KVM device, got: %s %v", container.Image, container.Resources)
+		}
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	if err := ValidateOutputFormat("output_format", OutputFormatVmdk); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := ValidateOutputFormat("output_format", "vdi"); err == nil || !strings.Contains(err.Error(), "'qcow2', 'vmdk', 'vhd', 'vhdx', 'raw', 'ova'") {
+		t.Fatalf("expected the allowed values in the error, got: %v", err)
+	}
+	if OutputFormatRaw.FileExtension() != "img" || OutputFormatVhdx.FileExtension() != "vhdx" {
+		t.Fatalf("unexpected file extensions")
+	}
+}
+
+func TestGenerateOvaJob(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+
+	job := GenerateOvaJob(vm, "ubuntu-source", "ubuntu-converted", "<Envelope/>", OvfOptions{Name: "ubuntu 24.04"}, GeneralizeOptions{Image: DefaultGuestFSImage})
+	container := job.Spec.Template.Spec.Containers[0]
+	command := strings.Join(container.Command, " ")
+	if !strings.Contains(command, `-O vmdk -o subformat=streamOptimized /source/disk.img "$OVA_DISK"`) {
+		t.Fatalf("expected a stream-optimized VMDK, got: %s", command)
+	}
+	if !strings.Contains(command, `tar --format=ustar -cf /converted/disk.img "$OVA_DESCRIPTOR" "$OVA_MANIFEST" "$OVA_DISK"`) {
+		t.Fatalf("expected the descriptor first in the archive, got: %s", command)
+	}
+	env := map[string]string{}
+	for _, variable := range container.Env {
+		env[variable.Name] = variable.Value
+	}
+	if env["OVF_DESCRIPTOR"] != "<Envelope/>" || env["OVA_DESCRIPTOR"] != "ubuntu 24.04.ovf" || env["OVA_DISK"] != "ubuntu 24.04-disk1.vmdk" {
+		t.Fatalf("unexpected environment: %v", env)
+	}
+}
@@ -0,0 +1,95 @@
+package generator
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maps"
+	"packer-plugin-kubevirt/builder/common"
+	"time"
+)
+
+const (
+	namespaceQuotaName      = "packer-build"
+	namespaceLimitRangeName = "packer-build"
+)
+
+// DefaultNamespaceQuota fits a default build VM, its virt-launcher overhead and the helper Jobs
+var DefaultNamespaceQuota = map[string]string{
+	string(corev1.ResourceRequestsCPU):            "8",
+	string(corev1.ResourceRequestsMemory):         "24Gi",
+	string(corev1.ResourcePersistentVolumeClaims): "10",
+}
+
+type NamespaceOptions struct {
+	Name  string
+	Quota map[string]string
+	// TTL is recorded as an expiry date, orphan cleanups of later builds delete the namespace past it
+	TTL         time.Duration
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func GenerateEphemeralNamespace(opts NamespaceOptions) *corev1.Namespace {
+	labels := maps.Clone(opts.Labels)
+	labels[common.EphemeralNamespaceLabel] = "true"
+	annotations := maps.Clone(opts.Annotations)
+	if opts.TTL > 0 {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[common.ExpiresAtAnnotation] = time.Now().Add(opts.TTL).UTC().Format(time.RFC3339)
+	}
+
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        opts.Name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+// GenerateResourceQuota expects quantities validated beforehand
+func GenerateResourceQuota(opts NamespaceOptions) *corev1.ResourceQuota {
+	hard := corev1.ResourceList{}
+	for name, quantity := range opts.Quota {
+		hard[corev1.ResourceName(name)] = resource.MustParse(quantity)
+	}
+
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespaceQuotaName,
+			Namespace:   opts.Name,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
+		},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: hard,
+		},
+	}
+}
+
+// GenerateLimitRange only sets default requests, so that helper Jobs without requests are admitted by the quota.
+// Limits are left out on purpose: 'libguestfs' and the uploaders are memory hungry on large disks.
+func GenerateLimitRange(opts NamespaceOptions) *corev1.LimitRange {
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespaceLimitRangeName,
+			Namespace:   opts.Name,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type: corev1.LimitTypeContainer,
+					DefaultRequest: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("256Mi"),
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,162 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
+	"packer-plugin-kubevirt/builder/common/vm"
+	"path"
+	"text/template"
+)
+
+const (
+	defaultOvfCPUs      = 1
+	defaultOvfMemoryMiB = 1024
+)
+
+// OvfOptions describes the package, the hardware is read from the Virtual Machine and its preference
+type OvfOptions struct {
+	// Name is the base name of the files in the package, e.g. '<name>.ovf'
+	Name       string
+	OsFamily   vm.OsFamily
+	Preference *instancetypev1beta1.VirtualMachinePreferenceSpec
+}
+
+// OvaFiles returns the descriptor, manifest and disk names, the descriptor has to come first in the archive
+func (o OvfOptions) OvaFiles() (descriptor, manifest, disk string) {
+	return o.Name + ".ovf", o.Name + ".mf", o.Name + "-disk1.vmdk"
+}
+
+type ovfNIC struct {
+	Name  string
+	Model string
+}
+
+type ovfDescriptor struct {
+	Name       string
+	DiskFile   string
+	OsId       string
+	OsType     string
+	CPUs       int64
+	MemoryMiB  int64
+	NICs       []ovfNIC
+	Firmware   string
+	SecureBoot bool
+}
+
+// GenerateOvfDescriptor renders the descriptor for vSphere. The disk size and capacity are only known once the disk
+// is converted, they are left as '@@DISK_SIZE@@' and '@@DISK_CAPACITY@@' for the conversion Job to fill in.
+func GenerateOvfDescriptor(virtualMachine *kubevirtv1.VirtualMachine, opts OvfOptions) (string, error) {
+	rawData, err := scripts.ReadFile(path.Join("scripts", "descriptor.ovf"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read OVF descriptor: %s", err)
+	}
+	tmpl, err := template.New("ovf").Funcs(template.FuncMap{
+		"add": func(a, b int) int { return a + b },
+	}).Parse(string(rawData))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse OVF descriptor: %s", err)
+	}
+
+	domain := virtualMachine.Spec.Template.Spec.Domain
+	_, _, disk := opts.OvaFiles()
+	descriptor := ovfDescriptor{
+		Name:      escapeXML(opts.Name),
+		DiskFile:  escapeXML(disk),
+		OsId:      "101",
+		OsType:    "otherLinux64Guest",
+		CPUs:      ovfCPUs(domain),
+		MemoryMiB: ovfMemoryMiB(domain),
+		NICs:      ovfNICs(domain, opts.Preference),
+		Firmware:  "bios",
+	}
+	if opts.OsFamily == vm.Windows {
+		descriptor.OsId = "1"
+		descriptor.OsType = "windows9Server64Guest"
+	}
+	if efi := ovfEFI(domain, opts.Preference); efi != nil {
+		descriptor.Firmware = "efi"
+		// KubeVirt enables secure boot unless it is explicitly disabled
+		descriptor.SecureBoot = efi.SecureBoot == nil || *efi.SecureBoot
+	}
+
+	var ovf bytes.Buffer
+	err = tmpl.Execute(&ovf, descriptor)
+	if err != nil {
+		return "", fmt.Errorf("failed to render OVF descriptor: %s", err)
+	}
+	return ovf.String(), nil
+}
+
+// ovfCPUs prefers the topology, the CPU request is rounded up otherwise
+func ovfCPUs(domain kubevirtv1.DomainSpec) int64 {
+	if cpu := domain.CPU; cpu != nil && cpu.Sockets+cpu.Cores+cpu.Threads > 0 {
+		return int64(max(cpu.Sockets, 1) * max(cpu.Cores, 1) * max(cpu.Threads, 1))
+	}
+	if request, ok := domain.Resources.Requests[corev1.ResourceCPU]; ok {
+		return max(request.Value(), 1)
+	}
+	return defaultOvfCPUs
+}
+
+func ovfMemoryMiB(domain kubevirtv1.DomainSpec) int64 {
+	var memory *resource.Quantity
+	if request, ok := domain.Resources.Requests[corev1.ResourceMemory]; ok {
+		memory = &request
+	}
+	if domain.Memory != nil && domain.Memory.Guest != nil {
+		memory = domain.Memory.Guest
+	}
+	if memory == nil {
+		return defaultOvfMemoryMiB
+	}
+	return max(memory.Value()/(1024*1024), 1)
+}
+
+// ovfNICs maps the emulated models, VMXNET3 replaces virtio which vSphere does not provide
+func ovfNICs(domain kubevirtv1.DomainSpec, preference *instancetypev1beta1.VirtualMachinePreferenceSpec) []ovfNIC {
+	var nics []ovfNIC
+	for _, iface := range domain.Devices.Interfaces {
+		model := iface.Model
+		if model == "" && preference != nil && preference.Devices != nil {
+			model = preference.Devices.PreferredInterfaceModel
+		}
+		nic := ovfNIC{Name: escapeXML(iface.Name), Model: "VmxNet3"}
+		switch model {
+		case "e1000":
+			nic.Model = "E1000"
+		case "e1000e":
+			nic.Model = "E1000e"
+		}
+		nics = append(nics, nic)
+	}
+	return nics
+}
+
+// ovfEFI returns nothing for BIOS, the firmware of the VM takes precedence over its preference
+func ovfEFI(domain kubevirtv1.DomainSpec, preference *instancetypev1beta1.VirtualMachinePreferenceSpec) *kubevirtv1.EFI {
+	if firmware := domain.Firmware; firmware != nil && firmware.Bootloader != nil {
+		return firmware.Bootloader.EFI
+	}
+	if preference == nil || preference.Firmware == nil {
+		return nil
+	}
+	firmware := preference.Firmware
+	if firmware.PreferredEfi != nil {
+		return firmware.PreferredEfi
+	}
+	if firmware.DeprecatedPreferredUseEfi != nil && *firmware.DeprecatedPreferredUseEfi {
+		return &kubevirtv1.EFI{SecureBoot: firmware.DeprecatedPreferredUseSecureBoot}
+	}
+	return nil
+}
+
+func escapeXML(value string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
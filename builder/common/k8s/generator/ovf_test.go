@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
+
+	"packer-plugin-kubevirt/builder/common/vm"
+)
+
+func TestGenerateOvfDescriptor(t *testing.T) {
+	virtualMachine := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "windows", Namespace: "packer"},
+		Spec: kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+			Spec: kubevirtv1.VirtualMachineInstanceSpec{Domain: kubevirtv1.DomainSpec{
+				Resources: kubevirtv1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1500m"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				}},
+				Devices: kubevirtv1.Devices{Interfaces: []kubevirtv1.Interface{{Name: "default"}, {Name: "backup", Model: "virtio"}}},
+			}},
+		}},
+	}
+	preference := &instancetypev1beta1.VirtualMachinePreferenceSpec{
+		Devices:  &instancetypev1beta1.DevicePreferences{PreferredInterfaceModel: "e1000e"},
+		Firmware: &instancetypev1beta1.FirmwarePreferences{PreferredEfi: &kubevirtv1.EFI{}},
+	}
+
+	descriptor, err := GenerateOvfDescriptor(virtualMachine, OvfOptions{Name: "win&2k22", OsFamily: vm.Windows, Preference: preference})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := xml.Unmarshal([]byte(descriptor), new(struct{})); err != nil {
+		t.Fatalf("expected a well-formed descriptor, got: %v", err)
+	}
+	expected := []string{
+		`ovf:href="win&amp;2k22-disk1.vmdk"`,
+		`vmw:osType="windows9Server64Guest"`,
+		`<rasd:VirtualQuantity>2</rasd:VirtualQuantity>`,
+		`<rasd:VirtualQuantity>8192</rasd:VirtualQuantity>`,
+		`<rasd:ResourceSubType>E1000e</rasd:ResourceSubType>`,
+		`<rasd:ResourceSubType>VmxNet3</rasd:ResourceSubType>`,
+		`vmw:key="firmware" vmw:value="efi"`,
+		`vmw:key="bootOptions.efiSecureBootEnabled"`,
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(descriptor, fragment) {
+			t.Fatalf("expected %q in the descriptor:\n%s", fragment, descriptor)
+		}
+	}
+}
+
+func TestGenerateOvfDescriptorDefaults(t *testing.T) {
+	virtualMachine := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Spec: kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+			Spec: kubevirtv1.VirtualMachineInstanceSpec{Domain: kubevirtv1.DomainSpec{
+				CPU: &kubevirtv1.CPU{Sockets: 2, Cores: 2},
+			}},
+		}},
+	}
+
+	descriptor, err := GenerateOvfDescriptor(virtualMachine, OvfOptions{Name: "ubuntu", OsFamily: vm.Linux})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := []string{
+		`vmw:osType="otherLinux64Guest"`,
+		`<rasd:VirtualQuantity>4</rasd:VirtualQuantity>`,
+		`<rasd:VirtualQuantity>1024</rasd:VirtualQuantity>`,
+		`vmw:key="firmware" vmw:value="bios"`,
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(descriptor, fragment) {
+			t.Fatalf("expected %q in the descriptor:\n%s", fragment, descriptor)
+		}
+	}
+	if strings.Contains(descriptor, "efiSecureBootEnabled") {
+		t.Fatalf("expected no secure boot with BIOS")
+	}
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"math"
+	"packer-plugin-kubevirt/builder/common"
+)
+
+const (
+	ConvertedVolumeSuffix = "converted"
+	// contentTypeAnnotation makes the export server serve the 'disk.img' file of the claim, whatever its format
+	contentTypeAnnotation = "cdi.kubevirt.io/storage.contentType"
+	// convertedVolumeOverhead is the share of the claim kept for the filesystem and the image metadata, as the
+	// default filesystem overhead of CDI. Incompressible data makes a qcow2 or VMDK image larger than the raw one.
+	convertedVolumeOverhead = 0.055
+	mebibyte                = 1 << 20
+)
+
+// GenerateConvertedVolume returns the claim holding the converted image, on the storage class of the source disk.
+// The filesystem mode is required, the image is a file whose size differs from the claim.
+func GenerateConvertedVolume(vm *kubevirtv1.VirtualMachine, source *corev1.PersistentVolumeClaim, format OutputFormat) *corev1.PersistentVolumeClaim {
+	annotations := common.InheritAnnotations(vm.Annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[contentTypeAnnotation] = string(cdiv1beta1.DataVolumeKubeVirt)
+	volumeMode := corev1.PersistentVolumeFilesystem
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        BuildConvertedVolumeName(vm.Name),
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: source.Spec.StorageClassName,
+			VolumeMode:       &volumeMode,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: convertedVolumeCapacity(source, format),
+				},
+			},
+		},
+	}
+}
+
+// convertedVolumeCapacity adds the overhead to the capacity of the source claim, rounded up to a mebibyte. An OVA
+// takes twice the capacity, the VMDK is only removed once the archive holding a copy of it is written.
+func convertedVolumeCapacity(source *corev1.PersistentVolumeClaim, format OutputFormat) resource.Quantity {
+	capacity := volumeCapacity(source)
+	copies := int64(1)
+	if format == OutputFormatOva {
+		copies = 2
+	}
+	size := int64(math.Ceil(float64(copies*capacity.Value()) / (1 - convertedVolumeOverhead)))
+	size = (size + mebibyte - 1) / mebibyte * mebibyte
+	return *resource.NewQuantity(size, resource.BinarySI)
+}
+
+// volumeCapacity prefers the provisioned capacity, which may be rounded up from the request
+func volumeCapacity(claim *corev1.PersistentVolumeClaim) resource.Quantity {
+	if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+		return capacity
+	}
+	return claim.Spec.Resources.Requests[corev1.ResourceStorage]
+}
+
+func BuildConvertedVolumeName(vmName string) string {
+	return fmt.Sprintf("%s-%s", vmName, ConvertedVolumeSuffix)
+}
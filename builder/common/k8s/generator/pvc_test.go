@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestGenerateConvertedVolumeCapacity(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"}}
+	source := &corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{Resources: corev1.VolumeResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("9Gi")},
+		}},
+		// The provisioner rounded the request up
+		Status: corev1.PersistentVolumeClaimStatus{Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}},
+	}
+
+	testCases := map[OutputFormat]string{
+		OutputFormatQcow2: "10836Mi",
+		// The VMDK and the archive coexist
+		OutputFormatOva: "21672Mi",
+	}
+	for format, expected := range testCases {
+		claim := GenerateConvertedVolume(vm, source, format)
+		capacity := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity.Cmp(resource.MustParse(expected)) != 0 {
+			t.Errorf("%s: expected the capacity of the source with the overhead (%s), got %s", format, expected, capacity.String())
+		}
+	}
+}
@@ -0,0 +1,24 @@
+# Fills the free space of the system drive with zeros, so that the exported image compresses it away
+$ErrorActionPreference = 'Stop'
+
+$drive = $env:SystemDrive.TrimEnd(':')
+$path = Join-Path $env:SystemDrive 'packer-zero-free-space.tmp'
+# The reserve keeps the guest responsive while the disk is full
+$reserve = 256MB
+$buffer = New-Object byte[] 64MB
+$free = (Get-PSDrive $drive).Free
+
+Write-Output ("zeroing {0:N1} GB of free space on {1}" -f (($free - $reserve) / 1GB), $env:SystemDrive)
+$stream = [System.IO.File]::Create($path)
+try {
+    for ($written = 0; $written + $buffer.Length -lt $free - $reserve; $written += $buffer.Length) {
+        $stream.Write($buffer, 0, $buffer.Length)
+    }
+} finally {
+    $stream.Close()
+    Remove-Item $path -Force
+}
+
+# Unmaps the zeroed blocks when the disk supports discard
+Optimize-Volume -DriveLetter $drive -ReTrim -ErrorAction SilentlyContinue
+Remove-Item $PSCommandPath -Force
@@ -0,0 +1,43 @@
+package generator
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"packer-plugin-kubevirt/builder/common"
+)
+
+// GenerateCommunicatorService exposes the guest ports inside the cluster, the Service is deleted along with the VM
+func GenerateCommunicatorService(vm *kubevirtv1.VirtualMachine, port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        BuildServiceName(vm.Name),
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: common.InheritAnnotations(vm.Annotations),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Selector: map[string]string{
+				kubevirtv1.VirtualMachineNameLabel: vm.Name,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "communicator",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       port,
+					TargetPort: intstr.FromInt32(port),
+				},
+			},
+		},
+	}
+}
+
+// BuildServiceName reuses the VM name, Service names are DNS-1035 labels which is checked at configuration time
+func BuildServiceName(vmName string) string {
+	return vmName
+}
@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"fmt"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/vm"
+)
+
+// ResourceNames lists the names of every resource a build creates for a given Virtual Machine name
+type ResourceNames struct {
+	VirtualMachine string
+	Export         string
+	Secrets        []string
+	DataVolumes    []string
+	// PersistentVolumeClaims are the claims created without a Data Volume, such as the converted image
+	PersistentVolumeClaims []string
+	Jobs                   []string
+	Service                string
+}
+
+// BuildUniqueName appends the build ID to the name, so that builds of the same template can run in the same namespace
+func BuildUniqueName(vmName, buildId string) string {
+	return fmt.Sprintf("%s-%s", vmName, buildId)
+}
+
+func BuildResourceNames(vmName string, family vm.OsFamily) ResourceNames {
+	names := ResourceNames{
+		VirtualMachine: vmName,
+		Export:         vmName,
+		Service:        BuildServiceName(vmName),
+		Secrets: []string{
+			buildSecretName(vmName, StartupScriptSecretSuffix),
+			buildSecretName(vmName, UserCredentialsSuffix),
+			buildSecretName(vmName, S3CredentialsSuffix),
+			buildSecretName(vmName, RootPasswordSuffix),
+			buildTokenSecretName(vmName),
+			buildTokenSecretName(BuildConvertedVolumeName(vmName)),
+		},
+		DataVolumes: []string{
+			BuildDataVolumeName(vmName, SourceDataVolumeSuffix),
+		},
+		PersistentVolumeClaims: []string{
+			BuildConvertedVolumeName(vmName),
+		},
+		Jobs: []string{
+			buildJobName(vmName, GuestFSJobSuffix),
+			buildJobName(vmName, SysprepLogsJobSuffix),
+			buildJobName(vmName, SparsifyJobSuffix),
+			buildJobName(vmName, QemuImgJobSuffix),
+		},
+	}
+	if family == vm.Windows {
+		names.DataVolumes = append(names.DataVolumes, BuildDataVolumeName(vmName, VirtioDataVolumeSuffix))
+	}
+
+	return names
+}
+
+// ValidateResourceNames checks the VM name along with every resource name derived from it,
+// so that a long 'kubernetes_name' fails at configuration time rather than halfway through a build.
+func ValidateResourceNames(vmName string, family vm.OsFamily) []error {
+	var errs []error
+	if err := common.ValidateDNS1123Label("kubernetes_name", vmName); err != nil {
+		return append(errs, err)
+	}
+
+	names := BuildResourceNames(vmName, family)
+
+	// Job names end up in the 'job-name' label of their pods, hence the stricter label format
+	for _, name := range names.Jobs {
+		if err := common.ValidateDNS1123Label("derived Job name", name); err != nil {
+			errs = append(errs, fmt.Errorf("kubernetes_name is too long: %w", err))
+		}
+	}
+
+	subdomainNames := append(append(append([]string{}, names.Secrets...), names.DataVolumes...), names.PersistentVolumeClaims...)
+	for _, name := range subdomainNames {
+		if err := common.ValidateDNS1123Subdomain("derived resource name", name); err != nil {
+			errs = append(errs, fmt.Errorf("kubernetes_name is too long: %w", err))
+		}
+	}
+
+	return errs
+}
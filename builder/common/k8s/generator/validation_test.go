@@ -0,0 +1,35 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"packer-plugin-kubevirt/builder/common/vm"
+)
+
+func TestBuildUniqueName(t *testing.T) {
+	name := BuildUniqueName("ubuntu", "a1b2c3")
+	if name != "ubuntu-a1b2c3" {
+		t.Fatalf("unexpected unique name: %s", name)
+	}
+
+	// Every derived resource name carries the build ID, so that builds of the same template don't conflict
+	names := BuildResourceNames(name, vm.Windows)
+	derived := append(append(append(append([]string{names.VirtualMachine, names.Export, names.Service},
+		names.Secrets...), names.DataVolumes...), names.PersistentVolumeClaims...), names.Jobs...)
+	for _, resourceName := range derived {
+		if !strings.HasPrefix(resourceName, "ubuntu-a1b2c3") {
+			t.Fatalf("expected %s to start with the unique name", resourceName)
+		}
+	}
+}
+
+func TestValidateResourceNamesWithUniqueName(t *testing.T) {
+	if errs := ValidateResourceNames(BuildUniqueName("ubuntu", "a1b2c3"), vm.Linux); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	long := strings.Repeat("a", 50)
+	if errs := ValidateResourceNames(BuildUniqueName(long, "a1b2c3"), vm.Linux); len(errs) == 0 {
+		t.Fatal("expected the derived Job names to be too long")
+	}
+}
@@ -6,11 +6,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
 	"packer-plugin-kubevirt/builder/common"
 	"packer-plugin-kubevirt/builder/common/vm"
 	"path"
+	"time"
 )
 
 //go:embed scripts/*
@@ -33,6 +35,23 @@ type VirtualMachineOptions struct {
 	ImageSource      ImageSource
 	UserProvisioning UserProvisioning
 	Credentials      *AccessCredentials
+	Labels           map[string]string
+	Annotations      map[string]string
+	// RunStrategy replaces 'running: true' when set, e.g. to keep the VM stopped once the guest powers itself off
+	RunStrategy *kubevirtv1.VirtualMachineRunStrategy
+	Readiness   ReadinessOptions
+}
+
+// ReadinessOptions configures the readiness probe, the durations are rounded down to seconds
+type ReadinessOptions struct {
+	Strategy common.ReadyStrategy
+	// Command run through the guest agent by the 'exec' strategy
+	Command []string
+	// Port of the guest checked by the 'tcp' strategy
+	Port         int
+	InitialDelay time.Duration
+	Period       time.Duration
+	Timeout      time.Duration
 }
 
 type AccessCredentials struct {
@@ -57,6 +76,7 @@ const (
 	StartupScriptSecretSuffix SecretSuffix = "startup-scripts"
 	UserCredentialsSuffix     SecretSuffix = "user-credentials"
 	S3CredentialsSuffix       SecretSuffix = "s3-credentials"
+	RootPasswordSuffix        SecretSuffix = "root-password"
 )
 
 func buildSecretName(vmName string, suffix SecretSuffix) string {
@@ -84,28 +104,26 @@ func BuildDataVolumeName(vmName string, suffix DataVolumeSuffix) string {
 	return fmt.Sprintf("%s-%s", vmName, suffix)
 }
 
-func buildProbeExecCommand(family vm.OsFamily) []string {
-	var command []string
-	switch family {
-	case vm.Linux:
-		command = []string{
-			"cloud-init",
-			"status",
-		}
-	case vm.Windows:
-		command = []string{
-			// NOTE: echo is 'acceptable' because qemu-ga is the last tool provisioned through sysprep.
-			"cmd",
-			"/c",
-			"echo",
-			// Error: Warning  Unhealthy kubelet: Readiness probe failed: {"component":"virt-probe","level":"fatal","msg":"Failed executing the command","pos":"virt-probe.go:71","reason":"rpc error: code = Unknown desc = virError(Code=1, Domain=0, Message='internal error: cannot parse json {\"execute\": \"guest-exec\", \"arguments\": { \"path\": \"findstr\", \"arg\": [ \"IMAGE_STATE_COMPLETE\", \"%SystemRoot%\\Setup\\State\\state.ini\" ], \"capture-output\":true } }: lexical error: inside a string, '\\' occurs before a character which it may not.
-			//"findstr",
-			//"IMAGE_STATE_COMPLETE",
-			//"C:\\Windows\\Setup\\State\\State.ini",
-		}
+// generateReadinessProbe returns no probe for the 'agent' strategy, the VM condition 'AgentConnected' is awaited instead
+func generateReadinessProbe(opts ReadinessOptions) *kubevirtv1.Probe {
+	var handler kubevirtv1.Handler
+	switch opts.Strategy {
+	case common.ReadyStrategyExec:
+		handler.Exec = &corev1.ExecAction{Command: opts.Command}
+	case common.ReadyStrategyTCP:
+		handler.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt32(int32(opts.Port))}
+	case common.ReadyStrategyCloudInit:
+		handler.Exec = &corev1.ExecAction{Command: common.CloudInitReadyCommand}
+	default:
+		return nil
 	}
 
-	return command
+	return &kubevirtv1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: int32(opts.InitialDelay.Seconds()),
+		PeriodSeconds:       int32(opts.Period.Seconds()),
+		TimeoutSeconds:      int32(opts.Timeout.Seconds()),
+	}
 }
 
 func GenerateStartupScriptSecret(virtualMachine *kubevirtv1.VirtualMachine, opts VirtualMachineOptions) (*corev1.Secret, error) {
@@ -138,8 +156,10 @@ func GenerateStartupScriptSecret(virtualMachine *kubevirtv1.VirtualMachine, opts
 
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildSecretName(opts.Name, StartupScriptSecretSuffix),
-			Namespace: opts.Namespace,
+			Name:        buildSecretName(opts.Name, StartupScriptSecretSuffix),
+			Namespace:   opts.Namespace,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(virtualMachine, kubevirtv1.VirtualMachineGroupVersionKind),
 			},
@@ -152,8 +172,10 @@ func GenerateStartupScriptSecret(virtualMachine *kubevirtv1.VirtualMachine, opts
 func GenerateS3CredentialsSecret(vm *kubevirtv1.VirtualMachine, opts VirtualMachineOptions) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildSecretName(opts.Name, S3CredentialsSuffix),
-			Namespace: opts.Namespace,
+			Name:        buildSecretName(opts.Name, S3CredentialsSuffix),
+			Namespace:   opts.Namespace,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
 			},
@@ -177,8 +199,10 @@ func GenerateUserCredentialsSecret(vm *kubevirtv1.VirtualMachine, opts VirtualMa
 
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildSecretName(opts.Name, UserCredentialsSuffix),
-			Namespace: opts.Namespace,
+			Name:        buildSecretName(opts.Name, UserCredentialsSuffix),
+			Namespace:   opts.Namespace,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(vm, kubevirtv1.VirtualMachineGroupVersionKind),
 			},
@@ -190,9 +214,12 @@ func GenerateUserCredentialsSecret(vm *kubevirtv1.VirtualMachine, opts VirtualMa
 
 func GenerateVirtualMachine(opts VirtualMachineOptions) *kubevirtv1.VirtualMachine {
 	isRunning := true
+	running := &isRunning
+	if opts.RunStrategy != nil {
+		running = nil
+	}
 	disks := generateDisks(opts.OsFamily)
 	volumes := generateVolumes(opts)
-	probeExecCommand := buildProbeExecCommand(opts.OsFamily)
 
 	var accessCredentials []kubevirtv1.AccessCredential
 	if opts.Credentials != nil {
@@ -216,32 +243,30 @@ func GenerateVirtualMachine(opts VirtualMachineOptions) *kubevirtv1.VirtualMachi
 			},
 		}
 	}
-	dataVolumeTemplates := generateDataVolumeTemplates(opts.OsFamily, dataVolumeSource, opts.Name, opts.DiskSpace)
+	dataVolumeTemplates := generateDataVolumeTemplates(opts, dataVolumeSource)
 
 	return &kubevirtv1.VirtualMachine{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      opts.Name,
-			Namespace: opts.Namespace,
+			Name:        opts.Name,
+			Namespace:   opts.Namespace,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 		},
 		Spec: kubevirtv1.VirtualMachineSpec{
-			Running: &isRunning,
+			Running:     running,
+			RunStrategy: opts.RunStrategy,
 			Preference: &kubevirtv1.PreferenceMatcher{
 				Kind: "VirtualMachineClusterPreference",
 				Name: opts.OsDistribution,
 			},
 			Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: opts.Labels,
+				},
 				Spec: kubevirtv1.VirtualMachineInstanceSpec{
-					NodeSelector: opts.NodeSelectors,
-					Tolerations:  opts.Tolerations,
-					ReadinessProbe: &kubevirtv1.Probe{
-						Handler: kubevirtv1.Handler{
-							Exec: &corev1.ExecAction{
-								Command: probeExecCommand,
-							},
-						},
-						InitialDelaySeconds: 30,
-						PeriodSeconds:       10,
-					},
+					NodeSelector:      opts.NodeSelectors,
+					Tolerations:       opts.Tolerations,
+					ReadinessProbe:    generateReadinessProbe(opts.Readiness),
 					AccessCredentials: accessCredentials,
 					Domain: kubevirtv1.DomainSpec{
 						Resources: kubevirtv1.ResourceRequirements{
@@ -279,11 +304,13 @@ func GenerateVirtualMachine(opts VirtualMachineOptions) *kubevirtv1.VirtualMachi
 	}
 }
 
-func generateDataVolumeTemplates(family vm.OsFamily, dvSource cdiv1beta1.DataVolumeSource, vmName, vmPrimaryDiskSpace string) []kubevirtv1.DataVolumeTemplateSpec {
+func generateDataVolumeTemplates(opts VirtualMachineOptions, dvSource cdiv1beta1.DataVolumeSource) []kubevirtv1.DataVolumeTemplateSpec {
 	templates := []kubevirtv1.DataVolumeTemplateSpec{
 		{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: BuildDataVolumeName(vmName, SourceDataVolumeSuffix),
+				Name:        BuildDataVolumeName(opts.Name, SourceDataVolumeSuffix),
+				Labels:      opts.Labels,
+				Annotations: opts.Annotations,
 			},
 			Spec: cdiv1beta1.DataVolumeSpec{
 				PVC: &corev1.PersistentVolumeClaimSpec{
@@ -292,7 +319,7 @@ func generateDataVolumeTemplates(family vm.OsFamily, dvSource cdiv1beta1.DataVol
 					},
 					Resources: corev1.VolumeResourceRequirements{
 						Requests: corev1.ResourceList{
-							corev1.ResourceStorage: resource.MustParse(vmPrimaryDiskSpace),
+							corev1.ResourceStorage: resource.MustParse(opts.DiskSpace),
 						},
 					},
 				},
@@ -301,10 +328,12 @@ func generateDataVolumeTemplates(family vm.OsFamily, dvSource cdiv1beta1.DataVol
 		},
 	}
 
-	if family == vm.Windows {
+	if opts.OsFamily == vm.Windows {
 		templates = append(templates, kubevirtv1.DataVolumeTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: BuildDataVolumeName(vmName, VirtioDataVolumeSuffix),
+				Name:        BuildDataVolumeName(opts.Name, VirtioDataVolumeSuffix),
+				Labels:      opts.Labels,
+				Annotations: opts.Annotations,
 			},
 			Spec: cdiv1beta1.DataVolumeSpec{
 				PVC: &corev1.PersistentVolumeClaimSpec{
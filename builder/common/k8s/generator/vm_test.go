@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"packer-plugin-kubevirt/builder/common"
+)
+
+func TestGenerateReadinessProbe(t *testing.T) {
+	timing := ReadinessOptions{InitialDelay: 30 * time.Second, Period: 10 * time.Second, Timeout: 5 * time.Second}
+
+	agent := timing
+	agent.Strategy = common.ReadyStrategyAgent
+	if probe := generateReadinessProbe(agent); probe != nil {
+		t.Fatalf("expected no probe for the agent strategy, got: %v", probe)
+	}
+
+	tcp := timing
+	tcp.Strategy = common.ReadyStrategyTCP
+	tcp.Port = common.DefaultWinRMPort
+	probe := generateReadinessProbe(tcp)
+	if probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != common.DefaultWinRMPort {
+		t.Fatalf("expected a TCP probe on port %d, got: %v", common.DefaultWinRMPort, probe)
+	}
+	if probe.InitialDelaySeconds != 30 || probe.PeriodSeconds != 10 || probe.TimeoutSeconds != 5 {
+		t.Fatalf("unexpected probe timing: %v", probe)
+	}
+
+	cloudInit := timing
+	cloudInit.Strategy = common.ReadyStrategyCloudInit
+	probe = generateReadinessProbe(cloudInit)
+	if probe == nil || probe.Exec == nil || len(probe.Exec.Command) != len(common.CloudInitReadyCommand) {
+		t.Fatalf("expected a 'cloud-init status --wait' probe, got: %v", probe)
+	}
+
+	exec := timing
+	exec.Strategy = common.ReadyStrategyExec
+	exec.Command = []string{"systemctl", "is-active", "nginx"}
+	probe = generateReadinessProbe(exec)
+	if probe == nil || probe.Exec == nil || probe.Exec.Command[0] != "systemctl" {
+		t.Fatalf("expected the custom command probe, got: %v", probe)
+	}
+}
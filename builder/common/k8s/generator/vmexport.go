@@ -4,28 +4,32 @@ import (
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 	exportv1 "kubevirt.io/api/export/v1beta1"
+	"packer-plugin-kubevirt/builder/common"
 	"packer-plugin-kubevirt/builder/common/k8s"
 )
 
 const (
 	tokenSecretSuffix = "export-token"
-	tokenSecretKey    = "token"
+	TokenSecretKey    = "token"
 )
 
 func GenerateTokenSecret(export *exportv1.VirtualMachineExport, token string) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildTokenSecretName(export.Spec.Source.Name),
-			Namespace: export.Namespace,
+			Name:        buildTokenSecretName(export.Spec.Source.Name),
+			Namespace:   export.Namespace,
+			Labels:      common.InheritLabels(export.Labels),
+			Annotations: common.InheritAnnotations(export.Annotations),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(export, exportv1.SchemeGroupVersion.WithKind(k8s.VirtualMachineExportKind)),
 			},
 		},
 		Type: corev1.SecretTypeOpaque,
 		StringData: map[string]string{
-			tokenSecretKey: token,
+			TokenSecretKey: token,
 		},
 	}
 }
@@ -40,8 +44,10 @@ func GenerateVirtualMachineExport(vm *kubevirtv1.VirtualMachine) *exportv1.Virtu
 
 	return &exportv1.VirtualMachineExport{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      vm.Name,
-			Namespace: vm.Namespace,
+			Name:        vm.Name,
+			Namespace:   vm.Namespace,
+			Labels:      common.InheritLabels(vm.Labels),
+			Annotations: common.InheritAnnotations(vm.Annotations),
 		},
 		Spec: exportv1.VirtualMachineExportSpec{
 			TokenSecretRef: &secretName,
@@ -50,6 +56,20 @@ func GenerateVirtualMachineExport(vm *kubevirtv1.VirtualMachine) *exportv1.Virtu
 	}
 }
 
+// GeneratePersistentVolumeClaimExport exposes the converted image rather than the disks of the VM, under the same name
+func GeneratePersistentVolumeClaimExport(vm *kubevirtv1.VirtualMachine, pvcName string) *exportv1.VirtualMachineExport {
+	export := GenerateVirtualMachineExport(vm)
+	export.Spec.Source = corev1.TypedLocalObjectReference{
+		APIGroup: pointer.String(corev1.SchemeGroupVersion.Group),
+		Kind:     "PersistentVolumeClaim",
+		Name:     pvcName,
+	}
+	secretName := buildTokenSecretName(pvcName)
+	export.Spec.TokenSecretRef = &secretName
+
+	return export
+}
+
 func buildTokenSecretName(vmName string) string {
 	return fmt.Sprintf("%s-%s", vmName, tokenSecretSuffix)
 }
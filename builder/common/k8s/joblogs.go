@@ -0,0 +1,178 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobLogsPollInterval = 2 * time.Second
+	// jobLogsDrainTimeout bounds the relay of the last log lines once the Job is done, streams end with their container
+	jobLogsDrainTimeout = 10 * time.Second
+	// JobFailureLogLines are the last log lines of the failed container included in the error
+	JobFailureLogLines = 20
+)
+
+// jobLogFollower relays the logs of every container of the Job pods, init containers included, as they start
+type jobLogFollower struct {
+	client kubernetes.Interface
+	ui     packersdk.Ui
+	job    *batchv1.Job
+
+	followed map[string]struct{}
+	// wg tracks the log streams, discovered tracks the lookup of started containers
+	wg            sync.WaitGroup
+	discovered    sync.WaitGroup
+	stop          chan struct{}
+	cancelStreams context.CancelFunc
+}
+
+func followJobLogs(ctx context.Context, client kubernetes.Interface, ui packersdk.Ui, job *batchv1.Job) *jobLogFollower {
+	ctx, cancel := context.WithCancel(ctx)
+	follower := &jobLogFollower{
+		client:        client,
+		ui:            ui,
+		job:           job,
+		followed:      make(map[string]struct{}),
+		stop:          make(chan struct{}),
+		cancelStreams: cancel,
+	}
+	follower.discovered.Add(1)
+	go func() {
+		defer follower.discovered.Done()
+		for {
+			follower.followStartedContainers(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-follower.stop:
+				// Containers that ran in between are followed once more, their logs are kept after they end
+				follower.followStartedContainers(ctx)
+				return
+			case <-time.After(jobLogsPollInterval):
+			}
+		}
+	}()
+	return follower
+}
+
+// drain lets the log streams relay the last lines of their containers, the ones still open after the grace period
+// are closed, e.g. the Job is done but a sidecar keeps running
+func (f *jobLogFollower) drain(grace time.Duration) {
+	close(f.stop)
+	f.discovered.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(grace):
+		f.cancelStreams()
+		<-drained
+	}
+	f.cancelStreams()
+}
+
+func (f *jobLogFollower) followStartedContainers(ctx context.Context) {
+	pods, err := listJobPods(ctx, f.client, f.job)
+	if err != nil {
+		return
+	}
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Running == nil && status.State.Terminated == nil {
+				continue
+			}
+			key := pod.Name + "/" + status.Name
+			if _, followed := f.followed[key]; followed {
+				continue
+			}
+			f.followed[key] = struct{}{}
+
+			f.wg.Add(1)
+			go func(podName, container string) {
+				defer f.wg.Done()
+				f.follow(ctx, podName, container)
+			}(pod.Name, status.Name)
+		}
+	}
+}
+
+func (f *jobLogFollower) follow(ctx context.Context, podName, container string) {
+	stream, err := f.client.CoreV1().Pods(f.job.Namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    true,
+	}).Stream(ctx)
+	if err != nil {
+		log.Printf("failed to follow logs of container %s of pod %s/%s: %v", container, f.job.Namespace, podName, err)
+		return
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		f.ui.Message(fmt.Sprintf("[%s] %s", container, scanner.Text()))
+	}
+}
+
+// jobFailureCause returns the exit code and the last log lines of the first container that failed
+func jobFailureCause(ctx context.Context, client kubernetes.Interface, job *batchv1.Job) string {
+	pods, err := listJobPods(ctx, client, job)
+	if err != nil {
+		return ""
+	}
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+
+			cause := fmt.Sprintf("container %s of pod %s exited with code %d", status.Name, pod.Name, terminated.ExitCode)
+			if terminated.Reason != "" {
+				cause = fmt.Sprintf("%s (%s)", cause, terminated.Reason)
+			}
+			tailLines := int64(JobFailureLogLines)
+			logs, err := client.CoreV1().Pods(job.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container: status.Name,
+				TailLines: &tailLines,
+			}).DoRaw(ctx)
+			if err == nil && len(strings.TrimSpace(string(logs))) > 0 {
+				cause = fmt.Sprintf("%s, last log lines:\n%s", cause, strings.TrimRight(string(logs), "\n"))
+			}
+			return cause
+		}
+	}
+	return ""
+}
+
+func listJobPods(ctx context.Context, client kubernetes.Interface, job *batchv1.Job) ([]corev1.Pod, error) {
+	pods, err := client.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			batchv1.JobNameLabel: job.Name,
+		}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
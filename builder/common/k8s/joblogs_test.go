@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestJobFailureCause(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "s3-uploader-ubuntu", Namespace: "packer"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "s3-uploader-ubuntu-x7b2k",
+			Namespace: "packer",
+			Labels:    map[string]string{batchv1.JobNameLabel: job.Name},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "download",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 22, Reason: "Error"}},
+			}},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+
+	cause := jobFailureCause(context.TODO(), client, job)
+	if !strings.Contains(cause, "container download of pod s3-uploader-ubuntu-x7b2k exited with code 22 (Error)") {
+		t.Fatalf("unexpected failure cause: %s", cause)
+	}
+	if !strings.Contains(cause, "last log lines") {
+		t.Fatalf("expected the last log lines in the failure cause: %s", cause)
+	}
+}
+
+func TestJobLogsDrainRelaysFinishedContainers(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "qemu-img-ubuntu", Namespace: "packer"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "qemu-img-ubuntu-p4m9s",
+			Namespace: "packer",
+			Labels:    map[string]string{batchv1.JobNameLabel: job.Name},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "convert",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			}},
+		},
+	}
+	ui := new(packersdk.MockUi)
+
+	// The Job is done before the streams are opened, they are still relayed
+	logs := followJobLogs(context.TODO(), fake.NewSimpleClientset(pod), ui, job)
+	logs.drain(time.Second)
+
+	if len(ui.SayMessages) != 1 || !strings.HasPrefix(ui.SayMessages[0].Message, "[convert] ") {
+		t.Fatalf("expected the logs of the finished container, got %v", ui.SayMessages)
+	}
+}
@@ -0,0 +1,224 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"time"
+)
+
+type OrphanResource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	BuildId   string
+	Age       time.Duration
+	delete    func(ctx context.Context) error
+}
+
+func (o OrphanResource) String() string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s %s (build '%s', %s old)", o.Kind, o.Name, o.BuildId, o.Age.Round(time.Second))
+	}
+	return fmt.Sprintf("%s %s/%s (build '%s', %s old)", o.Kind, o.Namespace, o.Name, o.BuildId, o.Age.Round(time.Second))
+}
+
+// ListOrphans returns every resource created by the plugin in the namespace that is older than the TTL, leaving out
+// the resources of the build currently running and of the builds still at work, e.g. a concurrent build running
+// longer than the TTL: their Virtual Machine runs or one of their Jobs is not finished.
+func ListOrphans(ctx context.Context, client kubecli.KubevirtClient, namespace string, ttl time.Duration, currentBuildId string) ([]OrphanResource, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			common.ManagedByLabel: common.ManagedByLabelValue,
+		}).String(),
+	}
+	foregroundDeletion := metav1.DeletePropagationForeground
+	backgroundDeletion := metav1.DeletePropagationBackground
+
+	vms, err := client.VirtualMachine(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Virtual Machines in %s: %w", namespace, err)
+	}
+	jobs, err := client.BatchV1().Jobs(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Jobs in %s: %w", namespace, err)
+	}
+	activeBuilds, activeVMs, err := listActiveBuilds(ctx, client, namespace, vms.Items, jobs.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []OrphanResource
+	appendIfOrphan := func(kind string, meta metav1.ObjectMeta, deleteFunc func(ctx context.Context) error) {
+		age := time.Since(meta.CreationTimestamp.Time)
+		buildId := meta.Labels[common.BuildIdLabel]
+		if age < ttl || (currentBuildId != "" && buildId == currentBuildId) {
+			return
+		}
+		if _, active := activeBuilds[buildId]; active && buildId != "" {
+			return
+		}
+		if owner := metav1.GetControllerOf(&meta); owner != nil && owner.Kind == "VirtualMachine" {
+			if _, active := activeVMs[owner.Name]; active {
+				return
+			}
+		}
+		if _, active := activeVMs[meta.Name]; active && kind == "VirtualMachine" {
+			return
+		}
+		orphans = append(orphans, OrphanResource{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			BuildId:   buildId,
+			Age:       age,
+			delete:    deleteFunc,
+		})
+	}
+
+	for _, vm := range vms.Items {
+		appendIfOrphan("VirtualMachine", vm.ObjectMeta, func(ctx context.Context) error {
+			return client.VirtualMachine(namespace).Delete(ctx, vm.Name, metav1.DeleteOptions{PropagationPolicy: &foregroundDeletion})
+		})
+	}
+
+	exports, err := client.VirtualMachineExport(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Virtual Machine Exports in %s: %w", namespace, err)
+	}
+	for _, export := range exports.Items {
+		appendIfOrphan(VirtualMachineExportKind, export.ObjectMeta, func(ctx context.Context) error {
+			return client.VirtualMachineExport(namespace).Delete(ctx, export.Name, metav1.DeleteOptions{})
+		})
+	}
+
+	for _, job := range jobs.Items {
+		appendIfOrphan("Job", job.ObjectMeta, func(ctx context.Context) error {
+			return client.BatchV1().Jobs(namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &backgroundDeletion})
+		})
+	}
+
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Secrets in %s: %w", namespace, err)
+	}
+	for _, secret := range secrets.Items {
+		appendIfOrphan("Secret", secret.ObjectMeta, func(ctx context.Context) error {
+			return client.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		})
+	}
+
+	services, err := client.CoreV1().Services(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Services in %s: %w", namespace, err)
+	}
+	for _, service := range services.Items {
+		appendIfOrphan("Service", service.ObjectMeta, func(ctx context.Context) error {
+			return client.CoreV1().Services(namespace).Delete(ctx, service.Name, metav1.DeleteOptions{})
+		})
+	}
+
+	// Claims of Data Volumes go along with their Virtual Machine, only the ones created by the plugin are swept here
+	claims, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Persistent Volume Claims in %s: %w", namespace, err)
+	}
+	for _, claim := range claims.Items {
+		if owner := metav1.GetControllerOf(&claim); owner != nil && owner.Kind == "DataVolume" {
+			continue
+		}
+		appendIfOrphan("PersistentVolumeClaim", claim.ObjectMeta, func(ctx context.Context) error {
+			return client.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, claim.Name, metav1.DeleteOptions{})
+		})
+	}
+
+	return orphans, nil
+}
+
+// listActiveBuilds returns the build IDs with a running Virtual Machine or an unfinished Job, along with the names of
+// the running Virtual Machines for the ones without a build ID
+func listActiveBuilds(ctx context.Context, client kubecli.KubevirtClient, namespace string, vms []kubevirtv1.VirtualMachine, jobs []batchv1.Job) (map[string]struct{}, map[string]struct{}, error) {
+	vmis, err := client.VirtualMachineInstance(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list Virtual Machine Instances in %s: %w", namespace, err)
+	}
+	activeVMs := map[string]struct{}{}
+	for _, vmi := range vmis.Items {
+		if !vmi.IsFinal() {
+			activeVMs[vmi.Name] = struct{}{}
+		}
+	}
+
+	activeBuilds := map[string]struct{}{}
+	for _, vm := range vms {
+		if _, active := activeVMs[vm.Name]; active {
+			activeBuilds[vm.Labels[common.BuildIdLabel]] = struct{}{}
+		}
+	}
+	for _, job := range jobs {
+		if !isJobFinished(&job) {
+			activeBuilds[job.Labels[common.BuildIdLabel]] = struct{}{}
+		}
+	}
+	return activeBuilds, activeVMs, nil
+}
+
+func isJobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// ListOrphanNamespaces returns the ephemeral namespaces of the whole cluster past their expiry date, leaving out the one
+// of the build currently running. Their age is not considered, a concurrent build may run longer than any TTL.
+func ListOrphanNamespaces(ctx context.Context, client kubecli.KubevirtClient, currentBuildId string) ([]OrphanResource, error) {
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			common.ManagedByLabel:          common.ManagedByLabelValue,
+			common.EphemeralNamespaceLabel: "true",
+		}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ephemeral namespaces: %w", err)
+	}
+
+	var orphans []OrphanResource
+	for _, namespace := range namespaces.Items {
+		age := time.Since(namespace.CreationTimestamp.Time)
+		buildId := namespace.Labels[common.BuildIdLabel]
+		expiresAt, err := time.Parse(time.RFC3339, namespace.Annotations[common.ExpiresAtAnnotation])
+		if err != nil || time.Now().Before(expiresAt) || (currentBuildId != "" && buildId == currentBuildId) {
+			continue
+		}
+		orphans = append(orphans, OrphanResource{
+			Kind:    "Namespace",
+			Name:    namespace.Name,
+			BuildId: buildId,
+			Age:     age,
+			delete: func(ctx context.Context) error {
+				return client.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{})
+			},
+		})
+	}
+
+	return orphans, nil
+}
+
+// DeleteOrphan deletes the resource, a resource already deleted by the garbage collector of its owner is not an error
+func DeleteOrphan(ctx context.Context, orphan OrphanResource) error {
+	err := orphan.delete(ctx)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %w", orphan, err)
+	}
+	return nil
+}
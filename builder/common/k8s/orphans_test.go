@@ -0,0 +1,101 @@
+package k8s
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+)
+
+func ephemeralNamespace(name, buildId string, age time.Duration, expiresAt *time.Time) *corev1.Namespace {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		Labels: map[string]string{
+			common.ManagedByLabel:          common.ManagedByLabelValue,
+			common.EphemeralNamespaceLabel: "true",
+			common.BuildIdLabel:            buildId,
+		},
+	}}
+	if expiresAt != nil {
+		namespace.Annotations = map[string]string{common.ExpiresAtAnnotation: expiresAt.UTC().Format(time.RFC3339)}
+	}
+	return namespace
+}
+
+func managedMeta(name, buildId string, age time.Duration) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "packer",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		Labels: map[string]string{
+			common.ManagedByLabel: common.ManagedByLabelValue,
+			common.BuildIdLabel:   buildId,
+		},
+	}
+}
+
+func TestListOrphans(t *testing.T) {
+	running := &kubevirtv1.VirtualMachine{ObjectMeta: managedMeta("debian", "running", 48*time.Hour)}
+	runningSecret := &corev1.Secret{ObjectMeta: managedMeta("debian-startup-scripts", "running", 48*time.Hour)}
+	runningSecret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(running, kubevirtv1.VirtualMachineGroupVersionKind)}
+	client := fake.NewVirtClient(
+		// A build killed before its cleanup
+		&kubevirtv1.VirtualMachine{ObjectMeta: managedMeta("ubuntu", "killed", 48*time.Hour)},
+		&corev1.Secret{ObjectMeta: managedMeta("ubuntu-startup-scripts", "killed", 48*time.Hour)},
+		// The build currently running
+		&kubevirtv1.VirtualMachine{ObjectMeta: managedMeta("centos", "current", 48*time.Hour)},
+		// A concurrent build running longer than the TTL
+		running,
+		runningSecret,
+		&kubevirtv1.VirtualMachineInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "debian", Namespace: "packer"},
+			Status:     kubevirtv1.VirtualMachineInstanceStatus{Phase: kubevirtv1.Running},
+		},
+		// A concurrent build converting its disk, its Virtual Machine is stopped
+		&kubevirtv1.VirtualMachine{ObjectMeta: managedMeta("fedora", "converting", 48*time.Hour)},
+		&batchv1.Job{ObjectMeta: managedMeta("fedora-qemu-img", "converting", time.Hour)},
+		// A build younger than the TTL
+		&corev1.Secret{ObjectMeta: managedMeta("rocky-startup-scripts", "recent", time.Hour)},
+	)
+
+	orphans, err := ListOrphans(context.TODO(), client, "packer", 24*time.Hour, "current")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, orphan := range orphans {
+		names = append(names, orphan.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"ubuntu", "ubuntu-startup-scripts"}) {
+		t.Fatalf("expected only the resources of the killed build, got %v", names)
+	}
+}
+
+func TestListOrphanNamespaces(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	client := fake.NewVirtClient(
+		ephemeralNamespace("packer-expired", "expired", 2*time.Hour, &past),
+		ephemeralNamespace("packer-valid", "valid", 2*time.Hour, &future),
+		ephemeralNamespace("packer-old", "old", 48*time.Hour, nil),
+		ephemeralNamespace("packer-current", "current", 48*time.Hour, &past),
+	)
+
+	orphans, err := ListOrphanNamespaces(context.TODO(), client, "current")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The old namespace without an expiry date may belong to a build still running
+	if len(orphans) != 1 || orphans[0].Name != "packer-expired" {
+		t.Fatalf("expected only the expired namespace, got %v", orphans)
+	}
+}
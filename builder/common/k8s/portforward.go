@@ -0,0 +1,318 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"log"
+	"net"
+	"packer-plugin-kubevirt/builder/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	portForwardInitialBackoff = time.Second
+	portForwardMaxBackoff     = 30 * time.Second
+)
+
+// PortForwarder keeps a tunnel open to the Virtual Machine, whichever virt-launcher pod currently runs it.
+// The launcher pod changes on VM restarts, e.g. a 'windows-restart' provisioner, and on live migrations.
+type PortForwarder struct {
+	Client         kubecli.KubevirtClient
+	Ui             packersdk.Ui
+	Namespace      string
+	VirtualMachine string
+	Ports          []string
+	// Transport falls back to the pod transport when the VMI subresource is not allowed
+	Transport common.PortForwardTransport
+	// Listeners reserve the allocated local ports, keyed by port. The VMI transport serves them, the pod transport
+	// binds the ports itself and closes them right before, which leaves a short window for another process.
+	Listeners map[int]net.Listener
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start returns once the first tunnel is ready, reconnections then happen in the background until Stop is called
+func (f *PortForwarder) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	f.cancel = cancel
+	f.done = make(chan struct{})
+
+	if f.Transport != common.PortForwardTransportPod {
+		err := f.canForwardThroughVMI(ctx)
+		if err == nil {
+			return f.startVMI(ctx)
+		}
+		f.Ui.Message(fmt.Sprintf("falling back to pod port-forwarding: %s", err))
+	}
+	f.closeListeners()
+
+	firstReady := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(f.done)
+		f.supervise(ctx, func() {
+			once.Do(func() { close(firstReady) })
+		})
+	}()
+
+	select {
+	case <-firstReady:
+		return nil
+	case <-time.After(PortFowardTimeout):
+		f.Stop()
+		return fmt.Errorf("timeout waiting for port forwarding to be ready")
+	}
+}
+
+func (f *PortForwarder) closeListeners() {
+	for _, listener := range f.Listeners {
+		_ = listener.Close()
+	}
+	f.Listeners = nil
+}
+
+func (f *PortForwarder) Stop() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+}
+
+func (f *PortForwarder) supervise(ctx context.Context, onReady func()) {
+	backoff := portForwardInitialBackoff
+	connected := false
+	for {
+		podName, err := f.resolveLauncherPod(ctx)
+		if err == nil {
+			err = f.forward(ctx, podName, func() {
+				if connected {
+					f.Ui.Message(fmt.Sprintf("port-forwarding has been re-established through pod %s/%s", f.Namespace, podName))
+				}
+				connected = true
+				backoff = portForwardInitialBackoff
+				onReady()
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			f.Ui.Message(fmt.Sprintf("port-forwarding to Virtual Machine %s/%s was interrupted (%v), reconnecting in %s...", f.Namespace, f.VirtualMachine, err, backoff))
+		} else {
+			log.Printf("port forwarding is not ready yet, retrying in %s: %v", backoff, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, portForwardMaxBackoff)
+	}
+}
+
+// canForwardThroughVMI checks the permission upfront, the subresource only fails once a connection is opened
+func (f *PortForwarder) canForwardThroughVMI(ctx context.Context) error {
+	review, err := f.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   f.Namespace,
+				Verb:        "get",
+				Group:       kubevirtv1.SubresourceGroupName,
+				Resource:    "virtualmachineinstances",
+				Subresource: "portforward",
+				Name:        f.VirtualMachine,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to review access to 'virtualmachineinstances/portforward': %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("access to 'virtualmachineinstances/portforward' is denied in %s", f.Namespace)
+	}
+	return nil
+}
+
+// startVMI listens locally and opens a new stream through KubeVirt for every connection, so that the communicator
+// reconnecting after a guest restart or a migration always reaches the current instance.
+func (f *PortForwarder) startVMI(ctx context.Context) error {
+	// Nothing runs in the background until the listeners are ready
+	abort := func() {
+		f.closeListeners()
+		f.cancel()
+		close(f.done)
+	}
+
+	mappings, err := parsePortMappings(f.Ports)
+	if err != nil {
+		abort()
+		return err
+	}
+
+	var listeners []net.Listener
+	for _, mapping := range mappings {
+		if listener, ok := f.Listeners[mapping.local]; ok {
+			listeners = append(listeners, listener)
+			delete(f.Listeners, mapping.local)
+			continue
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(common.VirtualMachineHost, strconv.Itoa(mapping.local)))
+		if err != nil {
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
+			abort()
+			return fmt.Errorf("failed to listen on local port %d: %w", mapping.local, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	var wg sync.WaitGroup
+	for i, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener, remotePort int) {
+			defer wg.Done()
+			f.acceptVMI(listener, remotePort)
+		}(listener, mappings[i].remote)
+	}
+	go func() {
+		<-ctx.Done()
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		wg.Wait()
+		close(f.done)
+	}()
+
+	return nil
+}
+
+func (f *PortForwarder) acceptVMI(listener net.Listener, remotePort int) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is only closed by Stop
+			return
+		}
+		go func() {
+			defer conn.Close()
+			stream, err := f.Client.VirtualMachineInstance(f.Namespace).PortForward(f.VirtualMachine, remotePort, "tcp")
+			if err != nil {
+				log.Printf("failed to open a stream to Virtual Machine Instance %s/%s: %v", f.Namespace, f.VirtualMachine, err)
+				return
+			}
+			err = stream.Stream(kubecli.StreamOptions{In: conn, Out: conn})
+			if err != nil {
+				log.Printf("stream to Virtual Machine Instance %s/%s was closed: %v", f.Namespace, f.VirtualMachine, err)
+			}
+		}()
+	}
+}
+
+type portMapping struct {
+	local  int
+	remote int
+}
+
+// parsePortMappings accepts the 'local:remote' format of pod port-forwarding
+func parsePortMappings(ports []string) ([]portMapping, error) {
+	var mappings []portMapping
+	for _, port := range ports {
+		local, remote, found := strings.Cut(port, ":")
+		if !found {
+			remote = local
+		}
+		localPort, err := strconv.Atoi(local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local port in '%s': %w", port, err)
+		}
+		remotePort, err := strconv.Atoi(remote)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote port in '%s': %w", port, err)
+		}
+		mappings = append(mappings, portMapping{local: localPort, remote: remotePort})
+	}
+	return mappings, nil
+}
+
+// forward blocks until the tunnel is closed, by the context or because the connection to the pod was lost
+func (f *PortForwarder) forward(ctx context.Context, podName string, onReady func()) error {
+	ready := make(chan struct{})
+	stop := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- runPortForward(f.Client, podName, f.Namespace, f.Ports, ready, stop)
+	}()
+
+	for {
+		select {
+		case <-ready:
+			onReady()
+			ready = nil
+		case err := <-result:
+			if err == nil {
+				err = fmt.Errorf("connection to pod %s/%s was closed", f.Namespace, podName)
+			}
+			return err
+		case <-ctx.Done():
+			close(stop)
+			<-result
+			return ctx.Err()
+		}
+	}
+}
+
+// resolveLauncherPod returns the running virt-launcher pod of the instance, completed or terminating pods are left out
+func (f *PortForwarder) resolveLauncherPod(ctx context.Context) (string, error) {
+	var nodeName string
+	vmi, err := f.Client.VirtualMachineInstance(f.Namespace).Get(ctx, f.VirtualMachine, metav1.GetOptions{})
+	if err == nil {
+		nodeName = vmi.Status.NodeName
+	}
+
+	pods, err := f.Client.CoreV1().Pods(f.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			kubevirtv1.VirtualMachineNameLabel: f.VirtualMachine,
+		}).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods of Virtual Machine %s/%s: %w", f.Namespace, f.VirtualMachine, err)
+	}
+
+	pod := SelectLauncherPod(pods.Items, nodeName)
+	if pod == nil {
+		return "", fmt.Errorf("no running pod found for Virtual Machine %s/%s", f.Namespace, f.VirtualMachine)
+	}
+	return pod.Name, nil
+}
+
+// SelectLauncherPod prefers the pod on the node of the instance, the migration target runs elsewhere until handover
+func SelectLauncherPod(pods []corev1.Pod, nodeName string) *corev1.Pod {
+	var candidate *corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if nodeName != "" && pod.Spec.NodeName == nodeName {
+			return pod
+		}
+		if candidate == nil || pod.CreationTimestamp.After(candidate.CreationTimestamp.Time) {
+			candidate = pod
+		}
+	}
+	return candidate
+}
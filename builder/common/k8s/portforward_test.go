@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSelectLauncherPod(t *testing.T) {
+	now := time.Now()
+	pod := func(name, node string, phase corev1.PodPhase, age time.Duration, deleting bool) corev1.Pod {
+		p := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if deleting {
+			p.DeletionTimestamp = &metav1.Time{Time: now}
+		}
+		return p
+	}
+
+	testCases := map[string]struct {
+		pods     []corev1.Pod
+		nodeName string
+		expected string
+	}{
+		"no pod": {},
+		"completed pod after a restart": {
+			pods: []corev1.Pod{
+				pod("old", "node-a", corev1.PodSucceeded, time.Hour, false),
+				pod("new", "node-b", corev1.PodRunning, time.Minute, false),
+			},
+			expected: "new",
+		},
+		"terminating pod": {
+			pods: []corev1.Pod{
+				pod("old", "node-a", corev1.PodRunning, time.Hour, true),
+			},
+		},
+		"migration in progress": {
+			pods: []corev1.Pod{
+				pod("source", "node-a", corev1.PodRunning, time.Hour, false),
+				pod("target", "node-b", corev1.PodRunning, time.Minute, false),
+			},
+			nodeName: "node-a",
+			expected: "source",
+		},
+		"unknown node": {
+			pods: []corev1.Pod{
+				pod("old", "node-a", corev1.PodRunning, time.Hour, false),
+				pod("new", "node-b", corev1.PodRunning, time.Minute, false),
+			},
+			expected: "new",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			selected := SelectLauncherPod(testCase.pods, testCase.nodeName)
+			if testCase.expected == "" {
+				if selected != nil {
+					t.Fatalf("expected no pod, got %s", selected.Name)
+				}
+				return
+			}
+			if selected == nil || selected.Name != testCase.expected {
+				t.Fatalf("expected pod %s, got %v", testCase.expected, selected)
+			}
+		})
+	}
+}
+
+func TestParsePortMappings(t *testing.T) {
+	mappings, err := parsePortMappings([]string{"2222:22", "5985"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []portMapping{{local: 2222, remote: 22}, {local: 5985, remote: 5985}}
+	if len(mappings) != len(expected) || mappings[0] != expected[0] || mappings[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, mappings)
+	}
+
+	_, err = parsePortMappings([]string{"ssh:22"})
+	if err == nil {
+		t.Fatalf("expected an error for a non-numeric port")
+	}
+}
+
+func TestStartVMIServesReservedListener(t *testing.T) {
+	listener, port, err := common.AllocateLocalPort()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	forwarder := &PortForwarder{
+		Client:         fake.NewVirtClient(),
+		Namespace:      "packer",
+		VirtualMachine: "ubuntu",
+		Ports:          []string{fmt.Sprintf("%d:22", port)},
+		Listeners:      map[int]net.Listener{port: listener},
+		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+	if err := forwarder.startVMI(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The port has been held since its allocation, another process can't take it over
+	if other, err := net.Listen("tcp", net.JoinHostPort(common.VirtualMachineHost, strconv.Itoa(port))); err == nil {
+		_ = other.Close()
+		t.Fatalf("expected local port %d to be served by the forwarder", port)
+	}
+
+	forwarder.Stop()
+	other, err := net.Listen("tcp", net.JoinHostPort(common.VirtualMachineHost, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("expected local port %d to be released once stopped: %v", port, err)
+	}
+	_ = other.Close()
+}
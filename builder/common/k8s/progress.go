@@ -0,0 +1,60 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// ImportProgressInterval throttles the progress messages of a Data Volume, phase changes are always reported
+const ImportProgressInterval = 30 * time.Second
+
+// ImportProgressReporter prints the CDI import progress, so that a slow download can be told apart from a stuck build
+type ImportProgressReporter struct {
+	KubeClient  client.Client
+	Ui          packersdk.Ui
+	Namespace   string
+	DataVolumes []string
+
+	last map[string]importProgress
+}
+
+type importProgress struct {
+	phase    cdiv1beta1.DataVolumePhase
+	progress cdiv1beta1.DataVolumeProgress
+	at       time.Time
+}
+
+// Report is best effort, Data Volumes not created yet or failing to be read are skipped
+func (r *ImportProgressReporter) Report(ctx context.Context) {
+	for _, name := range r.DataVolumes {
+		dataVolume := &cdiv1beta1.DataVolume{}
+		err := r.KubeClient.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, dataVolume)
+		if err != nil {
+			continue
+		}
+		if message, ok := r.update(name, dataVolume.Status, time.Now()); ok {
+			r.Ui.Message(message)
+		}
+	}
+}
+
+// update returns the message to print for the current status, if any
+func (r *ImportProgressReporter) update(name string, status cdiv1beta1.DataVolumeStatus, now time.Time) (string, bool) {
+	if r.last == nil {
+		r.last = make(map[string]importProgress)
+	}
+	last, seen := r.last[name]
+	if status.Phase == "" || (seen && status.Phase == last.phase && (status.Progress == last.progress || now.Sub(last.at) < ImportProgressInterval)) {
+		return "", false
+	}
+	r.last[name] = importProgress{phase: status.Phase, progress: status.Progress, at: now}
+
+	if status.Phase == cdiv1beta1.ImportInProgress && status.Progress != "" && status.Progress != "N/A" {
+		return fmt.Sprintf("Data Volume %s/%s is importing: %s", r.Namespace, name, status.Progress), true
+	}
+	return fmt.Sprintf("Data Volume %s/%s phase is '%s'", r.Namespace, name, status.Phase), true
+}
@@ -0,0 +1,32 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+func TestImportProgressReporterThrottling(t *testing.T) {
+	reporter := ImportProgressReporter{Namespace: "packer"}
+	start := time.Now()
+
+	steps := []struct {
+		status   cdiv1beta1.DataVolumeStatus
+		at       time.Time
+		expected string
+	}{
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportScheduled}, start, "Data Volume packer/ubuntu-source phase is 'ImportScheduled'"},
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportInProgress, Progress: "1.00%"}, start.Add(5 * time.Second), "Data Volume packer/ubuntu-source is importing: 1.00%"},
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportInProgress, Progress: "8.00%"}, start.Add(10 * time.Second), ""},
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportInProgress, Progress: "8.00%"}, start.Add(time.Minute), "Data Volume packer/ubuntu-source is importing: 8.00%"},
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.ImportInProgress, Progress: "42.00%"}, start.Add(time.Minute + 10*time.Second), ""},
+		{cdiv1beta1.DataVolumeStatus{Phase: cdiv1beta1.Succeeded, Progress: "100.0%"}, start.Add(time.Minute + 15*time.Second), "Data Volume packer/ubuntu-source phase is 'Succeeded'"},
+	}
+	for index, step := range steps {
+		message, _ := reporter.update("ubuntu-source", step.status, step.at)
+		if message != step.expected {
+			t.Fatalf("step %d: expected %q, got %q", index, step.expected, message)
+		}
+	}
+}
@@ -6,15 +6,8 @@ import (
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/watch"
-	v1 "k8s.io/client-go/kubernetes/typed/batch/v1"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/portforward"
-	watchtools "k8s.io/client-go/tools/watch"
 	"k8s.io/client-go/transport/spdy"
 	"kubevirt.io/client-go/kubecli"
 	"log"
@@ -70,51 +63,41 @@ func runPortForward(client kubecli.KubevirtClient, podName, namespace string, po
 	return forwarder.ForwardPorts()
 }
 
-type HandleEventFunc func(context.Context, watch.Event) (bool, error)
-
-func WaitForResource(client *rest.RESTClient, namespace, resource, name, version string, timeout time.Duration, handleEvent watchtools.ConditionFunc) (*watch.Event, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
-	defer cancel()
-
-	listWatch := cache.NewListWatchFromClient(client, resource, namespace, fields.OneTermEqualSelector("metadata.name", name))
-	event, err := watchtools.Until(ctx, version, listWatch, handleEvent)
-	if err != nil {
-		return nil, err
-	}
-
-	return event, nil
-}
-
-func WaitForJobCompletion(client v1.BatchV1Interface, ui packersdk.Ui, job *batchv1.Job, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
-	defer cancel()
-
-	watcher, err := client.Jobs(job.Namespace).Watch(ctx, metav1.ListOptions{
-		FieldSelector: labels.SelectorFromSet(map[string]string{
-			"metadata.name": job.Name,
-		}).String(),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to get job state %s/%s: %w", job.Namespace, job.Name, err)
-	}
-
-	for {
-		select {
-		case event, _ := <-watcher.ResultChan():
-			updatedJob, _ := event.Object.(*batchv1.Job)
-			for index, condition := range updatedJob.Status.Conditions {
-				if index == 0 {
-					ui.Message(fmt.Sprintf("condition '%s' changed to '%s'", condition.Type, condition.Status))
-				}
+// WaitForJobCompletion relays the logs of the Job containers to the UI, a failure is returned with the exit code
+// and the last log lines of the failed container.
+func WaitForJobCompletion(ctx context.Context, client kubernetes.Interface, ui packersdk.Ui, job *batchv1.Job, timeout time.Duration) error {
+	// The streams are closed right away when the build is cancelled, they are drained otherwise
+	logs := followJobLogs(ctx, client, ui, job)
+	defer logs.drain(jobLogsDrainTimeout)
+
+	_, err := WaitFor(ctx, WaitOptions[*batchv1.Job]{
+		Object:    &batchv1.Job{},
+		ListWatch: JobListWatch(ctx, client, job.Namespace, job.Name),
+		Timeout:   timeout,
+		Ui:        ui,
+		Progress: func(job *batchv1.Job) string {
+			if len(job.Status.Conditions) == 0 {
+				return ""
+			}
+			condition := job.Status.Conditions[0]
+			return fmt.Sprintf("condition '%s' changed to '%s'", condition.Type, condition.Status)
+		},
+		Done: func(updatedJob *batchv1.Job, exists bool) (bool, error) {
+			if !exists {
+				return false, fmt.Errorf("job %s/%s has been deleted", job.Namespace, job.Name)
+			}
+			for _, condition := range updatedJob.Status.Conditions {
 				if condition.Type == batchv1.JobComplete && condition.Status == corev1.ConditionTrue {
-					return nil
+					return true, nil
 				} else if (condition.Type == batchv1.JobFailed || condition.Type == batchv1.JobFailureTarget) && condition.Status == corev1.ConditionTrue {
-					return fmt.Errorf("job condition changed to failed")
+					if cause := jobFailureCause(ctx, client, job); cause != "" {
+						return false, fmt.Errorf("job condition changed to failed: %s", cause)
+					}
+					return false, fmt.Errorf("job condition changed to failed")
 				}
 			}
-
-		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for job to be completed")
-		}
-	}
+			return false, nil
+		},
+	})
+	return err
 }
@@ -8,27 +8,22 @@ import (
 
 func TestWaitForVirtualMachine(t *testing.T) {
 	//ns := "packer"
-	//resource := "virtualmachines"
 	//name := "image-builder"
 	//client, _ := GetKubevirtClient()
 	//
-	//vm, _ := client.VirtualMachine(ns).Get(context.TODO(), name, metav1.GetOptions{})
-	//
-	//conditionFunc := func(event watch.Event) (bool, error) {
-	//	vm, ok := event.Object.(*kubevirtv1.VirtualMachine)
-	//	if !ok {
-	//		return false, fmt.Errorf("unexpected type for %v", event.Object)
-	//	}
-	//
-	//	for _, condition := range vm.Status.Conditions {
-	//		if condition.Type == kubevirtv1.VirtualMachineReady && condition.Status == corev1.ConditionTrue {
-	//			return true, nil
+	//_, err := WaitFor(context.TODO(), WaitOptions[*kubevirtv1.VirtualMachine]{
+	//	Object:    &kubevirtv1.VirtualMachine{},
+	//	ListWatch: VirtualMachineListWatch(context.TODO(), client, ns, name),
+	//	Timeout:   10 * time.Minute,
+	//	Done: func(vm *kubevirtv1.VirtualMachine, exists bool) (bool, error) {
+	//		for _, condition := range vm.Status.Conditions {
+	//			if exists && condition.Type == kubevirtv1.VirtualMachineReady && condition.Status == corev1.ConditionTrue {
+	//				return true, nil
+	//			}
 	//		}
-	//	}
-	//	return false, nil
-	//}
-	//
-	//_, err := WaitForResource(client.RestClient(), vm.Namespace, resource, vm.Name, "51162567", 10*time.Minute, conditionFunc)
+	//		return false, nil
+	//	},
+	//})
 	//assert.NoError(t, err)
 }
 
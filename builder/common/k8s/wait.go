@@ -0,0 +1,176 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"kubevirt.io/client-go/kubecli"
+	"time"
+)
+
+const (
+	defaultCheckInterval    = 5 * time.Second
+	DefaultProgressInterval = 5 * time.Second
+)
+
+// WaitOptions describes the state awaited for a single resource. The wait is informer based: expired watches are
+// re-listed and transient API errors are retried with backoff, only the timeout, the context or a failure ends it.
+type WaitOptions[T runtime.Object] struct {
+	// Object is the type of the watched resource, e.g. '&batchv1.Job{}'
+	Object    T
+	ListWatch cache.ListerWatcher
+	Timeout   time.Duration
+	// Done is called on every change of the resource, 'exists' is false when it does not exist or has been deleted.
+	// An error stops the wait, e.g. on a terminal failure.
+	Done func(obj T, exists bool) (bool, error)
+	// Check is polled along with the watch, for failures reported by other resources than the awaited one
+	Check         func(ctx context.Context) error
+	CheckInterval time.Duration
+	// Progress describes the state of the resource, a new description is printed at most every ProgressInterval
+	Progress         func(obj T) string
+	ProgressInterval time.Duration
+	Ui               packersdk.Ui
+}
+
+// WaitFor returns the resource once Done is satisfied, or nothing if it was satisfied by a missing resource
+func WaitFor[T runtime.Object](ctx context.Context, opts WaitOptions[T]) (T, error) {
+	var result T
+	parent := ctx
+	ctx, cancel := watchtools.ContextWithOptionalTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	failure := make(chan error, 1)
+	if opts.Check != nil {
+		interval := opts.CheckInterval
+		if interval == 0 {
+			interval = defaultCheckInterval
+		}
+		go func() {
+			_ = wait.PollUntilContextCancel(ctx, interval, false, func(ctx context.Context) (bool, error) {
+				if err := opts.Check(ctx); err != nil {
+					failure <- err
+					cancel()
+					return true, nil
+				}
+				return false, nil
+			})
+		}()
+	}
+
+	progress := progressThrottle[T]{ui: opts.Ui, describe: opts.Progress, interval: opts.ProgressInterval}
+	precondition := func(store cache.Store) (bool, error) {
+		if len(store.List()) > 0 {
+			// The informer sends the existing resource as an 'Added' event
+			return false, nil
+		}
+		return opts.Done(result, false)
+	}
+	event, err := watchtools.UntilWithSync(ctx, opts.ListWatch, opts.Object, precondition, func(event watch.Event) (bool, error) {
+		obj, ok := event.Object.(T)
+		if !ok {
+			return false, fmt.Errorf("unexpected type %T", event.Object)
+		}
+		if event.Type == watch.Deleted {
+			return opts.Done(obj, false)
+		}
+		progress.report(obj)
+		return opts.Done(obj, true)
+	})
+	if err != nil {
+		select {
+		case err := <-failure:
+			return result, err
+		default:
+		}
+		if parent.Err() != nil {
+			return result, fmt.Errorf("wait was cancelled: %w", parent.Err())
+		}
+		if ctx.Err() != nil || errors.Is(err, wait.ErrWaitTimeout) {
+			return result, fmt.Errorf("timeout after %s", opts.Timeout)
+		}
+		return result, err
+	}
+
+	if event != nil {
+		result, _ = event.Object.(T)
+	}
+	return result, nil
+}
+
+type progressThrottle[T runtime.Object] struct {
+	ui       packersdk.Ui
+	describe func(T) string
+	interval time.Duration
+	last     string
+	at       time.Time
+}
+
+// report skips a description printed less than an interval ago, the next change prints the latest one
+func (p *progressThrottle[T]) report(obj T) {
+	if p.ui == nil || p.describe == nil {
+		return
+	}
+	description := p.describe(obj)
+	if description == "" || description == p.last || (p.last != "" && time.Since(p.at) < p.interval) {
+		return
+	}
+	p.last = description
+	p.at = time.Now()
+	p.ui.Message(description)
+}
+
+// namedListWatch restricts a list-watch to a single resource, the typed clients of any API group are supported
+func namedListWatch(name string, list func(metav1.ListOptions) (runtime.Object, error), watchFunc func(metav1.ListOptions) (watch.Interface, error)) *cache.ListWatch {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return list(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return watchFunc(options)
+		},
+	}
+}
+
+func JobListWatch(ctx context.Context, client kubernetes.Interface, namespace, name string) *cache.ListWatch {
+	jobs := client.BatchV1().Jobs(namespace)
+	return namedListWatch(name,
+		func(options metav1.ListOptions) (runtime.Object, error) { return jobs.List(ctx, options) },
+		func(options metav1.ListOptions) (watch.Interface, error) { return jobs.Watch(ctx, options) },
+	)
+}
+
+func VirtualMachineListWatch(ctx context.Context, virtClient kubecli.KubevirtClient, namespace, name string) *cache.ListWatch {
+	vms := virtClient.VirtualMachine(namespace)
+	return namedListWatch(name,
+		func(options metav1.ListOptions) (runtime.Object, error) { return vms.List(ctx, options) },
+		func(options metav1.ListOptions) (watch.Interface, error) { return vms.Watch(ctx, options) },
+	)
+}
+
+func VirtualMachineInstanceListWatch(ctx context.Context, virtClient kubecli.KubevirtClient, namespace, name string) *cache.ListWatch {
+	vmis := virtClient.VirtualMachineInstance(namespace)
+	return namedListWatch(name,
+		func(options metav1.ListOptions) (runtime.Object, error) { return vmis.List(ctx, options) },
+		func(options metav1.ListOptions) (watch.Interface, error) { return vmis.Watch(ctx, options) },
+	)
+}
+
+func VirtualMachineExportListWatch(ctx context.Context, virtClient kubecli.KubevirtClient, namespace, name string) *cache.ListWatch {
+	exports := virtClient.VirtualMachineExport(namespace)
+	return namedListWatch(name,
+		func(options metav1.ListOptions) (runtime.Object, error) { return exports.List(ctx, options) },
+		func(options metav1.ListOptions) (watch.Interface, error) { return exports.Watch(ctx, options) },
+	)
+}
@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForJobCompleted(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "guestfs-ubuntu", Namespace: "packer"}}
+	client := fake.NewSimpleClientset(job)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		completed := job.DeepCopy()
+		completed.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		_, _ = client.BatchV1().Jobs("packer").UpdateStatus(context.TODO(), completed, metav1.UpdateOptions{})
+	}()
+
+	result, err := WaitFor(context.TODO(), WaitOptions[*batchv1.Job]{
+		Object:    &batchv1.Job{},
+		ListWatch: JobListWatch(context.TODO(), client, job.Namespace, job.Name),
+		Timeout:   5 * time.Second,
+		Done: func(job *batchv1.Job, exists bool) (bool, error) {
+			return exists && len(job.Status.Conditions) > 0, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Status.Conditions[0].Type != batchv1.JobComplete {
+		t.Fatalf("unexpected job conditions: %v", result.Status.Conditions)
+	}
+}
+
+func TestWaitForMissingResource(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	result, err := WaitFor(context.TODO(), WaitOptions[*batchv1.Job]{
+		Object:    &batchv1.Job{},
+		ListWatch: JobListWatch(context.TODO(), client, "packer", "guestfs-ubuntu"),
+		Timeout:   5 * time.Second,
+		Done: func(_ *batchv1.Job, exists bool) (bool, error) {
+			return !exists, nil
+		},
+	})
+	if err != nil || result != nil {
+		t.Fatalf("expected the wait to be satisfied by the missing job, got %v, %v", result, err)
+	}
+}
+
+func TestWaitForFailures(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "guestfs-ubuntu", Namespace: "packer"}}
+	notDone := func(*batchv1.Job, bool) (bool, error) { return false, nil }
+
+	tests := []struct {
+		name  string
+		opts  WaitOptions[*batchv1.Job]
+		error string
+	}{
+		{
+			name:  "timeout",
+			opts:  WaitOptions[*batchv1.Job]{Timeout: 200 * time.Millisecond, Done: notDone},
+			error: "timeout after 200ms",
+		},
+		{
+			name: "fail-fast predicate",
+			opts: WaitOptions[*batchv1.Job]{Timeout: 5 * time.Second, Done: func(*batchv1.Job, bool) (bool, error) {
+				return false, errFailed
+			}},
+			error: "failed",
+		},
+		{
+			name: "failure check",
+			opts: WaitOptions[*batchv1.Job]{Timeout: 5 * time.Second, Done: notDone, CheckInterval: 50 * time.Millisecond, Check: func(context.Context) error {
+				return errFailed
+			}},
+			error: "failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(job)
+			tt.opts.Object = &batchv1.Job{}
+			tt.opts.ListWatch = JobListWatch(context.TODO(), client, job.Namespace, job.Name)
+
+			_, err := WaitFor(context.TODO(), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("expected error %q, got %v", tt.error, err)
+			}
+		})
+	}
+}
+
+func TestWaitForCancelled(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "guestfs-ubuntu", Namespace: "packer"}}
+	client := fake.NewSimpleClientset(job)
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := WaitFor(ctx, WaitOptions[*batchv1.Job]{
+		Object:    &batchv1.Job{},
+		ListWatch: JobListWatch(ctx, client, job.Namespace, job.Name),
+		Timeout:   20 * time.Minute,
+		Done: func(*batchv1.Job, bool) (bool, error) {
+			return false, nil
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error once the build is cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the cancellation to end the wait promptly, it took %s", elapsed)
+	}
+}
+
+func TestWaitForJobCompletionCancelled(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "guestfs-ubuntu", Namespace: "packer"}}
+	client := fake.NewSimpleClientset(job)
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// The log streams are closed right away, the drain grace period does not apply
+	start := time.Now()
+	err := WaitForJobCompletion(ctx, client, new(packersdk.MockUi), job, 20*time.Minute)
+	if err == nil {
+		t.Fatal("expected an error once the build is cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the cancellation to end the wait promptly, it took %s", elapsed)
+	}
+}
+
+var errFailed = errors.New("failed")
@@ -0,0 +1,63 @@
+package common
+
+import (
+	mathrandom "math/rand"
+	"packer-plugin-kubevirt/version"
+)
+
+const (
+	ManagedByLabel          = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue     = "packer-plugin-kubevirt"
+	BuildIdLabel            = "packer-plugin-kubevirt/build-id"
+	EphemeralNamespaceLabel = "packer-plugin-kubevirt/ephemeral"
+	BuildNameAnnotation     = "packer-plugin-kubevirt/build-name"
+	PluginVersionAnnotation = "packer-plugin-kubevirt/version"
+	ExpiresAtAnnotation     = "packer-plugin-kubevirt/expires-at"
+
+	BuildIdLength = 8
+)
+
+// GenerateBuildId returns a short random identifier, valid as a label value and as a DNS-1123 name suffix
+func GenerateBuildId() string {
+	var letters = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+
+	b := make([]rune, BuildIdLength)
+	for i := range b {
+		b[i] = letters[mathrandom.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+func BuildLabels(buildId string) map[string]string {
+	return map[string]string{
+		ManagedByLabel: ManagedByLabelValue,
+		BuildIdLabel:   buildId,
+	}
+}
+
+func BuildAnnotations(buildName string) map[string]string {
+	return map[string]string{
+		BuildNameAnnotation:     buildName,
+		PluginVersionAnnotation: version.PluginVersion.String(),
+	}
+}
+
+// InheritLabels copies the labels managed by the plugin, leaving out the ones added by other controllers
+func InheritLabels(labels map[string]string) map[string]string {
+	return inherit(labels, ManagedByLabel, BuildIdLabel)
+}
+
+// InheritAnnotations copies the annotations managed by the plugin, leaving out the ones added by other controllers
+func InheritAnnotations(annotations map[string]string) map[string]string {
+	return inherit(annotations, BuildNameAnnotation, PluginVersionAnnotation)
+}
+
+func inherit(values map[string]string, keys ...string) map[string]string {
+	inherited := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			inherited[key] = value
+		}
+	}
+	return inherited
+}
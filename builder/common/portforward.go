@@ -0,0 +1,20 @@
+package common
+
+// PortForwardTransport defines the API used to tunnel the communicator to the guest
+type PortForwardTransport string
+
+const (
+	// PortForwardTransportVMI goes through the 'virtualmachineinstances/portforward' subresource, as 'virtctl port-forward'
+	PortForwardTransportVMI PortForwardTransport = "vmi"
+	// PortForwardTransportPod goes through the 'pods/portforward' subresource of the virt-launcher pod
+	PortForwardTransportPod PortForwardTransport = "pod"
+)
+
+var portForwardTransports = []PortForwardTransport{
+	PortForwardTransportVMI,
+	PortForwardTransportPod,
+}
+
+func ValidatePortForwardTransport(field string, transport PortForwardTransport) error {
+	return ValidateOneOf(field, transport, portForwardTransports)
+}
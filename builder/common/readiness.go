@@ -0,0 +1,40 @@
+package common
+
+import "packer-plugin-kubevirt/builder/common/vm"
+
+// ReadyStrategy defines how the Virtual Machine is declared ready before the communicator connects
+type ReadyStrategy string
+
+const (
+	// ReadyStrategyAgent waits for the guest agent to be connected, the Windows sysprep installs it as the final step
+	ReadyStrategyAgent ReadyStrategy = "agent"
+	// ReadyStrategyExec runs a custom command through the guest agent until it succeeds
+	ReadyStrategyExec ReadyStrategy = "exec"
+	// ReadyStrategyTCP checks that the communicator port of the guest accepts connections
+	ReadyStrategyTCP ReadyStrategy = "tcp"
+	// ReadyStrategyCloudInit waits for cloud-init to have finished, successfully or not
+	ReadyStrategyCloudInit ReadyStrategy = "cloud-init"
+)
+
+// CloudInitReadyCommand blocks until every cloud-init stage has run, the probe timeout bounds each attempt.
+// 'cloud-init status' exits with 1 on errors and 2 on recoverable errors, the guest is reachable all the same.
+var CloudInitReadyCommand = []string{"sh", "-c", "cloud-init status --wait; [ $? -le 2 ]"}
+
+var readyStrategies = []ReadyStrategy{
+	ReadyStrategyAgent,
+	ReadyStrategyExec,
+	ReadyStrategyTCP,
+	ReadyStrategyCloudInit,
+}
+
+// DefaultReadyStrategy returns the strategy matching the provisioning of the OS family, cloud-init or sysprep
+func DefaultReadyStrategy(family vm.OsFamily) ReadyStrategy {
+	if family == vm.Windows {
+		return ReadyStrategyAgent
+	}
+	return ReadyStrategyCloudInit
+}
+
+func ValidateReadyStrategy(field string, strategy ReadyStrategy) error {
+	return ValidateOneOf(field, strategy, readyStrategies)
+}
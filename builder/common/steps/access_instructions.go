@@ -0,0 +1,105 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"time"
+)
+
+// defaultLocalPortOffset keeps the suggested local port out of the privileged range, e.g. 22 becomes 10022
+const defaultLocalPortOffset = 10000
+
+const accessInstructionsTimeout = 10 * time.Second
+
+// AccessInstructions describes how to reach a Virtual Machine kept after a failed build
+type AccessInstructions struct {
+	VirtualMachine *kubevirtv1.VirtualMachine
+	LauncherPod    string
+	Comm           communicator.Config
+	// LocalPort suggested for port-forwarding, the one used by the build is free again once it has stopped
+	LocalPort int
+}
+
+// Commands returns ready-to-run commands, the launcher pod is only known once the VM has been scheduled
+func (a AccessInstructions) Commands() []string {
+	ns := a.VirtualMachine.Namespace
+	name := a.VirtualMachine.Name
+
+	commands := []string{
+		fmt.Sprintf("virtctl console %s -n %s", name, ns),
+		fmt.Sprintf("virtctl vnc %s -n %s", name, ns),
+	}
+
+	var remotePort int
+	switch a.Comm.Type {
+	case "ssh":
+		remotePort = common.DefaultSSHPort
+		sshCommand := fmt.Sprintf("virtctl ssh %s@vm/%s -n %s --local-ssh-opts='-o StrictHostKeyChecking=no'", common.VirtualMachineUsername, name, ns)
+		if a.Comm.SSHPrivateKeyFile != "" {
+			sshCommand = fmt.Sprintf("%s -i %s", sshCommand, a.Comm.SSHPrivateKeyFile)
+		}
+		commands = append(commands, sshCommand)
+	case "winrm":
+		remotePort = common.DefaultWinRMPort
+	}
+
+	if remotePort != 0 {
+		localPort := common.GetOrDefault(a.LocalPort, remotePort+defaultLocalPortOffset)
+		commands = append(commands, fmt.Sprintf("virtctl port-forward vm/%s -n %s %d:%d", name, ns, localPort, remotePort))
+		if a.LauncherPod != "" {
+			commands = append(commands, fmt.Sprintf("kubectl port-forward pod/%s -n %s %d:%d", a.LauncherPod, ns, localPort, remotePort))
+		}
+	}
+
+	return commands
+}
+
+// Credentials returns the way to authenticate, the password is the one baked by the startup scripts
+func (a AccessInstructions) Credentials() string {
+	if a.Comm.Type == "ssh" && a.Comm.SSHPrivateKeyFile != "" {
+		return fmt.Sprintf("username '%s', private key '%s'", common.VirtualMachineUsername, a.Comm.SSHPrivateKeyFile)
+	}
+	return fmt.Sprintf("username '%s', password '%s'", common.VirtualMachineUsername, common.VirtualMachinePassword)
+}
+
+// PrintAccessInstructions is best effort, a missing launcher pod only leaves out the 'kubectl' command
+func PrintAccessInstructions(virtClient kubecli.KubevirtClient, ui packer.Ui, vm *kubevirtv1.VirtualMachine, comm communicator.Config, localPort int) {
+	instructions := AccessInstructions{
+		VirtualMachine: vm,
+		Comm:           comm,
+		LocalPort:      localPort,
+	}
+	// The build context is already cancelled when the build has been interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), accessInstructionsTimeout)
+	defer cancel()
+	pods, err := virtClient.CoreV1().Pods(vm.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			kubevirtv1.VirtualMachineNameLabel: vm.Name,
+		}).String(),
+	})
+	if err == nil {
+		// The instance may be gone already, any running launcher pod is suggested then
+		var nodeName string
+		if vmi, err := virtClient.VirtualMachineInstance(vm.Namespace).Get(ctx, vm.Name, metav1.GetOptions{}); err == nil {
+			nodeName = vmi.Status.NodeName
+		}
+		if pod := k8s.SelectLauncherPod(pods.Items, nodeName); pod != nil {
+			instructions.LauncherPod = pod.Name
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Virtual Machine %s/%s has been kept for debugging, delete it once done:", vm.Namespace, vm.Name))
+	for _, command := range instructions.Commands() {
+		ui.Message(command)
+	}
+	ui.Message(fmt.Sprintf("credentials: %s", instructions.Credentials()))
+	ui.Message(fmt.Sprintf("kubectl delete vm/%s -n %s", vm.Name, vm.Namespace))
+}
@@ -0,0 +1,48 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"time"
+)
+
+const diagnosticsTimeout = 2 * time.Minute
+
+// collectDiagnostics writes a diagnostics bundle of a failed build. It is called by the cleanup of the deployment
+// step, so that a failure of the deployment itself is covered and the bundle is written before the VM is deleted.
+func (s *StepDeployVM) collectDiagnostics(state multistep.StateBag, vm *kubevirtv1.VirtualMachine) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if s.DiagnosticsDir == "" || (!cancelled && !halted) {
+		return
+	}
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+
+	names := generator.BuildResourceNames(vm.Name, s.VmOptions.OsFamily)
+	diagnostics := k8s.Diagnostics{
+		VirtClient:             s.VirtClient,
+		KubeClient:             s.KubeClient,
+		Namespace:              vm.Namespace,
+		VirtualMachine:         vm.Name,
+		DataVolumes:            names.DataVolumes,
+		PersistentVolumeClaims: names.PersistentVolumeClaims,
+		Jobs:                   names.Jobs,
+		Export:                 names.Export,
+	}
+	ui.Say(fmt.Sprintf("collecting diagnostics of Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	// The build context is already cancelled on interruption
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+	dir, err := diagnostics.Collect(ctx, s.DiagnosticsDir)
+	if err != nil {
+		ui.Error(fmt.Sprintf("failed to collect diagnostics: %s", err))
+		return
+	}
+	ui.Message(fmt.Sprintf("diagnostics have been written to %s", dir))
+}
@@ -0,0 +1,104 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+)
+
+// StepEphemeralNamespace isolates the build in its own namespace. On success, the namespace outlives the build
+// for post-processors to download the export, it is deleted when Packer destroys the artifact. Packer never destroys
+// an artifact that is kept, hence the expiry date that the orphan cleanup of later builds enforces.
+type StepEphemeralNamespace struct {
+	VirtClient       kubecli.KubevirtClient
+	NamespaceOptions generator.NamespaceOptions
+	KeepOnError      bool
+	// DeleteAfterBuild deletes the namespace on success too, for builds whose artifact is not destroyed, e.g. without
+	// post-processors or with 'keep_input_artifact'
+	DeleteAfterBuild bool
+	created          bool
+}
+
+func (s *StepEphemeralNamespace) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	ns := s.NamespaceOptions.Name
+
+	ui.Say(fmt.Sprintf("creating ephemeral namespace %s...", ns))
+	namespace := generator.GenerateEphemeralNamespace(s.NamespaceOptions)
+	_, err := s.VirtClient.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if err != nil {
+		err := fmt.Errorf("failed to create ephemeral namespace %s: %s", ns, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+	s.created = true
+
+	quota := generator.GenerateResourceQuota(s.NamespaceOptions)
+	_, err = s.VirtClient.CoreV1().ResourceQuotas(ns).Create(ctx, quota, metav1.CreateOptions{})
+	if err != nil {
+		err := fmt.Errorf("failed to create resource quota in ephemeral namespace %s: %s", ns, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	limitRange := generator.GenerateLimitRange(s.NamespaceOptions)
+	_, err = s.VirtClient.CoreV1().LimitRanges(ns).Create(ctx, limitRange, metav1.CreateOptions{})
+	if err != nil {
+		err := fmt.Errorf("failed to create limit range in ephemeral namespace %s: %s", ns, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("ephemeral namespace %s is ready", ns))
+
+	return multistep.ActionContinue
+}
+
+// Cleanup only deletes the namespace when the build did not complete unless asked to, post-processors may need it
+func (s *StepEphemeralNamespace) Cleanup(state multistep.StateBag) {
+	if !s.created {
+		return
+	}
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted && !s.DeleteAfterBuild {
+		return
+	}
+	if halted && s.KeepOnError {
+		// The Virtual Machine kept for debugging lives in this namespace, the orphan cleanup deletes it eventually
+		return
+	}
+
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	ns := s.NamespaceOptions.Name
+
+	ui.Say(fmt.Sprintf("deleting ephemeral namespace %s...", ns))
+	ctx, cancel := k8s.CleanupContext()
+	defer cancel()
+	err := k8s.DeleteResourceAndWait(ctx, k8s.NamespaceOperations(s.VirtClient), "", ns, k8s.ResourceDeletionTimeout)
+	if err != nil {
+		ui.Error(err.Error())
+		return
+	}
+	ui.Message(fmt.Sprintf("ephemeral namespace %s has been deleted", ns))
+}
+
+// DeleteEphemeralNamespace is meant to be called by the artifact, once every post-processor has run
+func DeleteEphemeralNamespace(virtClient kubecli.KubevirtClient, ns string) error {
+	ctx, cancel := k8s.CleanupContext()
+	defer cancel()
+	return k8s.DeleteResourceAndWait(ctx, k8s.NamespaceOperations(virtClient), "", ns, k8s.ResourceDeletionTimeout)
+}
@@ -0,0 +1,37 @@
+package steps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+)
+
+func TestEphemeralNamespaceCleanupOnSuccess(t *testing.T) {
+	for _, deleteAfterBuild := range []bool{false, true} {
+		client := fake.NewVirtClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "packer-a1b2c3"}})
+		step := &StepEphemeralNamespace{
+			VirtClient:       client,
+			NamespaceOptions: generator.NamespaceOptions{Name: "packer-a1b2c3"},
+			DeleteAfterBuild: deleteAfterBuild,
+			created:          true,
+		}
+		state := new(multistep.BasicStateBag)
+		appContext := &common.AppContext{State: state}
+		appContext.Put(common.PackerUi, new(packersdk.MockUi))
+
+		step.Cleanup(state)
+
+		_, err := client.CoreV1().Namespaces().Get(context.TODO(), "packer-a1b2c3", metav1.GetOptions{})
+		if deleted := k8serrors.IsNotFound(err); deleted != deleteAfterBuild {
+			t.Errorf("expected the namespace to be deleted after the build: %t, got: %t (%v)", deleteAfterBuild, deleted, err)
+		}
+	}
+}
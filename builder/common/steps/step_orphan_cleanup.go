@@ -0,0 +1,74 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"time"
+)
+
+// StepCleanupOrphans deletes the resources left behind by previous builds that were killed before their cleanup,
+// e.g. the Virtual Machine Export and its token secret which are kept on purpose for post-processors.
+type StepCleanupOrphans struct {
+	VirtClient kubecli.KubevirtClient
+	Namespaces []string
+	TTL        time.Duration
+	// ExpiredNamespaces deletes the ephemeral namespaces of the whole cluster past their expiry date, e.g. the ones
+	// kept for post-processors that never destroyed the artifact. The TTL does not apply to namespaces.
+	ExpiredNamespaces bool
+}
+
+func (s *StepCleanupOrphans) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.TTL == 0 && !s.ExpiredNamespaces {
+		return multistep.ActionContinue
+	}
+
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+
+	if s.ExpiredNamespaces {
+		ui.Say("looking for expired ephemeral namespaces left over by previous builds...")
+		namespaceOrphans, err := k8s.ListOrphanNamespaces(ctx, s.VirtClient, appContext.GetBuildId())
+		if err != nil {
+			ui.Error(fmt.Sprintf("skipping ephemeral namespace cleanup: %s", err))
+		}
+		s.deleteOrphans(ctx, ui, namespaceOrphans)
+	}
+
+	if s.TTL == 0 {
+		return multistep.ActionContinue
+	}
+
+	for _, ns := range s.Namespaces {
+		ui.Say(fmt.Sprintf("looking for resources older than %s left over by previous builds in %s...", s.TTL, ns))
+		orphans, err := k8s.ListOrphans(ctx, s.VirtClient, ns, s.TTL, appContext.GetBuildId())
+		if err != nil {
+			// Best effort, a missing permission on a shared namespace should not prevent the build
+			ui.Error(fmt.Sprintf("skipping orphan cleanup in %s: %s", ns, err))
+			continue
+		}
+
+		s.deleteOrphans(ctx, ui, orphans)
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepCleanupOrphans) deleteOrphans(ctx context.Context, ui packer.Ui, orphans []k8s.OrphanResource) {
+	for _, orphan := range orphans {
+		err := k8s.DeleteOrphan(ctx, orphan)
+		if err != nil {
+			ui.Error(err.Error())
+			continue
+		}
+		ui.Message(fmt.Sprintf("%s has been deleted", orphan))
+	}
+}
+
+func (s *StepCleanupOrphans) Cleanup(_ multistep.StateBag) {
+	// Nothing to clean up, this step only deletes resources
+}
@@ -0,0 +1,47 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+)
+
+// StepStreamEvents forwards the Warning events of the build resources until the end of the build.
+// It runs before the deployment, the events of the Data Volume import are often the first ones to matter.
+type StepStreamEvents struct {
+	VirtClient kubecli.KubevirtClient
+	Namespace  string
+	// Name of the Virtual Machine, every resource of the build is named after it
+	Name     string
+	streamer *k8s.EventStreamer
+}
+
+func (s *StepStreamEvents) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+
+	streamer := &k8s.EventStreamer{
+		Client:    s.VirtClient,
+		Ui:        ui,
+		Namespace: s.Namespace,
+		Names:     []string{s.Name},
+	}
+	// Events are only a troubleshooting aid, the build goes on without them
+	err := streamer.Start(ctx)
+	if err != nil {
+		ui.Message(fmt.Sprintf("warning events will not be shown: %s", err))
+		return multistep.ActionContinue
+	}
+	s.streamer = streamer
+
+	return multistep.ActionContinue
+}
+
+func (s *StepStreamEvents) Cleanup(_ multistep.StateBag) {
+	if s.streamer != nil {
+		s.streamer.Stop()
+	}
+}
@@ -0,0 +1,76 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"time"
+)
+
+// StepWaitForCompletion replaces the communicator when the guest configures itself, e.g. with cloud-init 'runcmd'
+type StepWaitForCompletion struct {
+	VirtClient kubecli.KubevirtClient
+	Signal     common.CompletionSignal
+	Timeout    time.Duration
+}
+
+func (s *StepWaitForCompletion) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	ui.Say(fmt.Sprintf("waiting for the guest of Virtual Machine %s/%s to signal completion (%s)...", vm.Namespace, vm.Name, s.Signal))
+	_, err := k8s.WaitFor(ctx, k8s.WaitOptions[*kubevirtv1.VirtualMachineInstance]{
+		Object:    &kubevirtv1.VirtualMachineInstance{},
+		ListWatch: k8s.VirtualMachineInstanceListWatch(ctx, s.VirtClient, vm.Namespace, vm.Name),
+		Timeout:   s.Timeout,
+		Ui:        ui,
+		Progress: func(vmi *kubevirtv1.VirtualMachineInstance) string {
+			return fmt.Sprintf("phase '%s'", vmi.Status.Phase)
+		},
+		Done: func(vmi *kubevirtv1.VirtualMachineInstance, exists bool) (bool, error) {
+			if !exists {
+				// The instance is only deleted on power off when the run strategy does not keep it
+				return s.Signal == common.CompletionSignalPowerOff, nil
+			}
+
+			switch s.Signal {
+			case common.CompletionSignalPowerOff:
+				if vmi.Status.Phase == kubevirtv1.Failed {
+					return false, fmt.Errorf("the guest stopped with a failure")
+				}
+				return vmi.Status.Phase == kubevirtv1.Succeeded, nil
+			case common.CompletionSignalGuestAgent:
+				if vmi.IsFinal() {
+					return false, fmt.Errorf("the guest stopped before the completion command succeeded")
+				}
+				for _, condition := range vmi.Status.Conditions {
+					if condition.Type == kubevirtv1.VirtualMachineInstanceReady && condition.Status == corev1.ConditionTrue {
+						return true, nil
+					}
+				}
+			}
+			return false, nil
+		},
+	})
+	if err != nil {
+		err := fmt.Errorf("failed to wait for completion of Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("completion step has completed for Virtual Machine %s/%s", vm.Namespace, vm.Name))
+
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForCompletion) Cleanup(_ multistep.StateBag) {
+	// Nothing to clean up, the Virtual Machine is deleted by the deployment step
+}
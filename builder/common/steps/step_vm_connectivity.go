@@ -0,0 +1,117 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"time"
+)
+
+const connectivityTimeout = 2 * time.Minute
+
+// StepDirectConnectivity resolves the address of the guest when Packer runs inside the cluster, no tunnel is needed
+type StepDirectConnectivity struct {
+	VirtClient     kubecli.KubevirtClient
+	Mode           common.ConnectivityMode
+	Comm           communicator.Config
+	ConflictPolicy common.ConflictPolicy
+}
+
+func (s *StepDirectConnectivity) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	port, err := guestCommunicatorPort(s.Comm)
+	if err != nil {
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	var host string
+	switch s.Mode {
+	case common.ConnectivityModePodIP:
+		host, err = s.resolveInstanceIP(ctx, vm)
+	case common.ConnectivityModeService:
+		host, err = s.createService(ctx, vm, port)
+	default:
+		err = fmt.Errorf("unsupported connectivity mode '%s'", s.Mode)
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to resolve the address of Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+	appContext.Put(common.CommunicatorHost, host)
+	appContext.Put(common.CommunicatorPort, port)
+	putCommunicatorGeneratedData(&packerbuilderdata.GeneratedData{State: state}, s.Comm, host, port)
+
+	ui.Say(fmt.Sprintf("connectivity step has completed for Virtual Machine %s/%s, reachable at %s:%d", vm.Namespace, vm.Name, host, port))
+
+	return multistep.ActionContinue
+}
+
+// resolveInstanceIP waits for the guest interface to be reported, with masquerade it is the IP of the launcher pod
+func (s *StepDirectConnectivity) resolveInstanceIP(ctx context.Context, vm *kubevirtv1.VirtualMachine) (string, error) {
+	var ip string
+	_, err := k8s.WaitFor(ctx, k8s.WaitOptions[*kubevirtv1.VirtualMachineInstance]{
+		Object:    &kubevirtv1.VirtualMachineInstance{},
+		ListWatch: k8s.VirtualMachineInstanceListWatch(ctx, s.VirtClient, vm.Namespace, vm.Name),
+		Timeout:   connectivityTimeout,
+		Done: func(vmi *kubevirtv1.VirtualMachineInstance, exists bool) (bool, error) {
+			if !exists {
+				return false, nil
+			}
+			for _, iface := range vmi.Status.Interfaces {
+				if iface.IP != "" {
+					ip = iface.IP
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to wait for the Virtual Machine Instance to report an IP: %w", err)
+	}
+	return ip, nil
+}
+
+// createService returns the cluster IP rather than the DNS name, the cluster DNS may not be used by Packer
+func (s *StepDirectConnectivity) createService(ctx context.Context, vm *kubevirtv1.VirtualMachine, port int) (string, error) {
+	service := generator.GenerateCommunicatorService(vm, int32(port))
+	service, err := k8s.CreateResource(ctx, k8s.ServiceOperations(s.VirtClient, vm.Namespace), service, s.ConflictPolicy)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Service: %w", err)
+	}
+	if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == "None" {
+		return "", fmt.Errorf("Service %s/%s has no cluster IP", service.Namespace, service.Name)
+	}
+	return service.Spec.ClusterIP, nil
+}
+
+func guestCommunicatorPort(comm communicator.Config) (int, error) {
+	switch comm.Type {
+	case "ssh":
+		return common.DefaultSSHPort, nil
+	case "winrm":
+		return common.DefaultWinRMPort, nil
+	default:
+		return 0, fmt.Errorf("unsupported communicator type, allowed values: 'ssh', 'winrm'")
+	}
+}
+
+func (s *StepDirectConnectivity) Cleanup(_ multistep.StateBag) {
+	// The Service is owned by the Virtual Machine, it is deleted along with it
+}
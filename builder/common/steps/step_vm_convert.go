@@ -2,20 +2,144 @@ package steps
 
 import (
 	"context"
+	"fmt"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/api/instancetype"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
 )
 
+// StepConvertVM converts the disk of the stopped Virtual Machine into a new claim with 'qemu-img', the export step
+// then exposes that claim instead of the disks of the Virtual Machine. Nothing is done without an output format, nor
+// for 'raw', the disks of the Virtual Machine are already raw images.
 type StepConvertVM struct {
+	VirtClient kubecli.KubevirtClient
+	// KubeClient reads the preference of the Virtual Machine, for the hardware of the OVF descriptor
+	KubeClient     client.Client
+	ConflictPolicy common.ConflictPolicy
+	OutputFormat   generator.OutputFormat
+	// Options provides the image and the resources of the Job, shared with the generalization
+	Options generator.GeneralizeOptions
+	Timeout time.Duration
 }
 
-func (s *StepConvertVM) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
-	// TODO: current is VM export downloaded (init c.) -> uploaded (c.)
-	// post-download, it should be converted (e.g. qcow2) if an output conversion format is set.
-	// a RWX PVC could be used or S3 csi?
+func (s *StepConvertVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.OutputFormat == "" || s.OutputFormat == generator.OutputFormatRaw {
+		return multistep.ActionContinue
+	}
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	ui.Say(fmt.Sprintf("converting with 'qemu-img' the disk of Virtual Machine %s/%s to %s...", vm.Namespace, vm.Name, s.OutputFormat))
+
+	// The files of the package are named after the image, resource names may carry an extra unique suffix
+	ovf := generator.OvfOptions{
+		Name:     appContext.GetImageName(),
+		OsFamily: *appContext.GetVirtualMachineOSFamily(),
+	}
+	if ovf.Name == "" {
+		ovf.Name = vm.Name
+	}
+	err := s.convert(ctx, ui, vm, ovf)
+	if err != nil {
+		err := fmt.Errorf("error with 'qemu-img' job %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+	appContext.Put(common.OutputFormat, string(s.OutputFormat))
+
+	ui.Say(fmt.Sprintf("conversion step has completed for Virtual Machine %s/%s", vm.Namespace, vm.Name))
 
 	return multistep.ActionContinue
 }
 
+// convert sizes the destination claim after the source one, with headroom for the formats exceeding the raw image
+func (s *StepConvertVM) convert(ctx context.Context, ui packer.Ui, vm *kubevirtv1.VirtualMachine, ovf generator.OvfOptions) error {
+	sourceName := generator.BuildDataVolumeName(vm.Name, generator.SourceDataVolumeSuffix)
+	source, err := s.VirtClient.CoreV1().PersistentVolumeClaims(vm.Namespace).Get(ctx, sourceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the source Persistent Volume Claim: %w", err)
+	}
+
+	destination := generator.GenerateConvertedVolume(vm, source, s.OutputFormat)
+	_, err = k8s.CreateResource(ctx, k8s.PersistentVolumeClaimOperations(s.VirtClient, vm.Namespace), destination, s.ConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to create the destination Persistent Volume Claim: %w", err)
+	}
+
+	var job *batchv1.Job
+	if s.OutputFormat == generator.OutputFormatOva {
+		job, err = s.generateOvaJob(ctx, vm, sourceName, destination.Name, ovf)
+		if err != nil {
+			return err
+		}
+	} else {
+		job = generator.GenerateQemuImgJob(vm, sourceName, destination.Name, s.OutputFormat, s.Options)
+	}
+	job, err = k8s.CreateResource(ctx, k8s.JobOperations(s.VirtClient, vm.Namespace), job, s.ConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+
+	return k8s.WaitForJobCompletion(ctx, s.VirtClient, ui, job, s.Timeout)
+}
+
+// generateOvaJob describes the hardware of the Virtual Machine, the firmware and NIC model may only be set by its preference
+func (s *StepConvertVM) generateOvaJob(ctx context.Context, vm *kubevirtv1.VirtualMachine, sourceName, destinationName string, ovf generator.OvfOptions) (*batchv1.Job, error) {
+	preference, err := s.getPreference(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+	ovf.Preference = preference
+
+	descriptor, err := generator.GenerateOvfDescriptor(vm, ovf)
+	if err != nil {
+		return nil, err
+	}
+	return generator.GenerateOvaJob(vm, sourceName, destinationName, descriptor, ovf, s.Options), nil
+}
+
+// getPreference resolves the preference matcher as KubeVirt does, a missing kind stands for a cluster preference.
+// A reused Virtual Machine may refer to a namespaced preference.
+func (s *StepConvertVM) getPreference(ctx context.Context, vm *kubevirtv1.VirtualMachine) (*instancetypev1beta1.VirtualMachinePreferenceSpec, error) {
+	matcher := vm.Spec.Preference
+	if matcher == nil || matcher.Name == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(matcher.Kind) {
+	case "", instancetype.ClusterSingularPreferenceResourceName, instancetype.ClusterPluralPreferenceResourceName:
+		preference := &instancetypev1beta1.VirtualMachineClusterPreference{}
+		err := s.KubeClient.Get(ctx, types.NamespacedName{Name: matcher.Name}, preference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Virtual Machine Cluster Preference %s: %w", matcher.Name, err)
+		}
+		return &preference.Spec, nil
+	case instancetype.SingularPreferenceResourceName, instancetype.PluralPreferenceResourceName:
+		preference := &instancetypev1beta1.VirtualMachinePreference{}
+		err := s.KubeClient.Get(ctx, types.NamespacedName{Namespace: vm.Namespace, Name: matcher.Name}, preference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Virtual Machine Preference %s/%s: %w", vm.Namespace, matcher.Name, err)
+		}
+		return &preference.Spec, nil
+	default:
+		return nil, fmt.Errorf("unsupported preference kind %s", matcher.Kind)
+	}
+}
+
 func (s *StepConvertVM) Cleanup(_ multistep.StateBag) {
-	// Cleaning up 'Virtual Machine Conversion' during the build would prevent any post-processor to download the export
+	// The destination claim is owned by the Virtual Machine, it is kept for the export until the artifact is destroyed
 }
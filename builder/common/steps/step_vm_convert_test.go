@@ -0,0 +1,44 @@
+package steps
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+)
+
+func TestGetPreference(t *testing.T) {
+	clusterFirmware := &instancetypev1beta1.FirmwarePreferences{PreferredUseBios: new(bool)}
+	namespacedFirmware := &instancetypev1beta1.FirmwarePreferences{PreferredUseBiosSerial: new(bool)}
+	step := &StepConvertVM{KubeClient: fake.NewKubeClient(
+		&instancetypev1beta1.VirtualMachineClusterPreference{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows.2k22"},
+			Spec:       instancetypev1beta1.VirtualMachinePreferenceSpec{Firmware: clusterFirmware},
+		},
+		&instancetypev1beta1.VirtualMachinePreference{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows.2k22", Namespace: "packer"},
+			Spec:       instancetypev1beta1.VirtualMachinePreferenceSpec{Firmware: namespacedFirmware},
+		},
+	)}
+	testCases := map[string]*instancetypev1beta1.FirmwarePreferences{
+		"":                                clusterFirmware,
+		"VirtualMachineClusterPreference": clusterFirmware,
+		"VirtualMachinePreference":        namespacedFirmware,
+		"virtualmachinepreference":        namespacedFirmware,
+	}
+
+	for kind, expected := range testCases {
+		vm := buildVirtualMachine()
+		vm.Spec.Preference = &kubevirtv1.PreferenceMatcher{Name: "windows.2k22", Kind: kind}
+		preference, err := step.getPreference(context.TODO(), vm)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", kind, err)
+		}
+		if preference == nil || preference.Firmware == nil || (preference.Firmware.PreferredUseBios == nil) != (expected.PreferredUseBios == nil) {
+			t.Errorf("%q: expected the preference of kind %q, got %v", kind, kind, preference)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
 	"packer-plugin-kubevirt/builder/common"
@@ -18,21 +17,41 @@ import (
 	"time"
 )
 
+const failureCheckInterval = 5 * time.Second
+
 type StepDeployVM struct {
 	KubeClient          client.Client
 	VirtClient          kubecli.KubevirtClient
 	VmOptions           generator.VirtualMachineOptions
 	VmDeploymentTimeOut time.Duration
+	ConflictPolicy      common.ConflictPolicy
+	UniqueName          bool
+	KeepOnError         bool
+	// SkipReadyWait only waits for the instance to be created, readiness is then awaited by a later step
+	SkipReadyWait bool
+	// DiagnosticsDir receives a diagnostics bundle when the build fails, none is collected when empty
+	DiagnosticsDir string
+
+	// reused is set when the 'reuse' policy picks up an existing Virtual Machine, which is then left in place
+	reused bool
 }
 
-func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+func (s *StepDeployVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	appContext := &common.AppContext{State: state}
 	ui := appContext.GetPackerUi()
+	if s.UniqueName {
+		s.VmOptions.Name = generator.BuildUniqueName(s.VmOptions.Name, appContext.GetBuildId())
+	}
 	ns := s.VmOptions.Namespace
 	name := s.VmOptions.Name
 
-	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}
-	_, err := s.VirtClient.CoreV1().Namespaces().Create(context.TODO(), namespace, metav1.CreateOptions{})
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: ns,
+		Labels: map[string]string{
+			common.ManagedByLabel: common.ManagedByLabelValue,
+		},
+	}}
+	_, err := s.VirtClient.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
 	if err != nil && !errors.IsAlreadyExists(err) {
 		err := fmt.Errorf("failed to create namespace for Virtual Machine %s/%s: %s", ns, name, err)
 		appContext.Put(common.PackerError, err)
@@ -41,9 +60,19 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 		return multistep.ActionHalt
 	}
 
+	err = s.resolveConflicts(ctx, ui, appContext.GetBuildId())
+	if err != nil {
+		err := fmt.Errorf("failed to resolve conflicts with existing resources for Virtual Machine %s/%s: %s", ns, name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+	name = s.VmOptions.Name
+
 	ui.Say(fmt.Sprintf("creating Virtual Machine %s/%s...", ns, name))
 	vm := generator.GenerateVirtualMachine(s.VmOptions)
-	vm, err = s.VirtClient.VirtualMachine(ns).Create(context.TODO(), vm, metav1.CreateOptions{})
+	vm, s.reused, err = k8s.CreateOrReuseResource(ctx, k8s.VirtualMachineOperations(s.VirtClient, ns), vm, s.ConflictPolicy)
 	if err != nil {
 		err := fmt.Errorf("failed to create Virtual Machine %s/%s: %s", ns, name, err)
 		appContext.Put(common.PackerError, err)
@@ -53,9 +82,10 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 	}
 	appContext.Put(common.VirtualMachine, vm)
 
+	secretOperations := k8s.SecretOperations(s.VirtClient, ns)
 	if s.VmOptions.ImageSource.AWSAccessKeyId != "" && s.VmOptions.ImageSource.AWSSecretAccessKey != "" {
 		s3CredentialsSecret := generator.GenerateS3CredentialsSecret(vm, s.VmOptions)
-		_, err = s.VirtClient.CoreV1().Secrets(ns).Create(context.TODO(), s3CredentialsSecret, metav1.CreateOptions{})
+		_, err = k8s.CreateResource(ctx, secretOperations, s3CredentialsSecret, s.ConflictPolicy)
 		if err != nil {
 			err := fmt.Errorf("failed to create s3 credentials secret for Virtual Machine %s/%s: %s", ns, name, err)
 			appContext.Put(common.PackerError, err)
@@ -73,7 +103,7 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 
 		return multistep.ActionHalt
 	}
-	_, err = s.VirtClient.CoreV1().Secrets(ns).Create(context.TODO(), startupScriptSecret, metav1.CreateOptions{})
+	_, err = k8s.CreateResource(ctx, secretOperations, startupScriptSecret, s.ConflictPolicy)
 	if err != nil {
 		err := fmt.Errorf("failed to create startup script secret for Virtual Machine %s/%s: %s", ns, name, err)
 		appContext.Put(common.PackerError, err)
@@ -84,7 +114,7 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 
 	if s.VmOptions.Credentials != nil {
 		userCredentialsSecret := generator.GenerateUserCredentialsSecret(vm, s.VmOptions)
-		_, err = s.VirtClient.CoreV1().Secrets(ns).Create(context.TODO(), userCredentialsSecret, metav1.CreateOptions{})
+		_, err = k8s.CreateResource(ctx, secretOperations, userCredentialsSecret, s.ConflictPolicy)
 		if err != nil {
 			err := fmt.Errorf("failed to create user credentials secret for Virtual Machine %s/%s: %s", ns, name, err)
 			appContext.Put(common.PackerError, err)
@@ -94,7 +124,7 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 		}
 	}
 
-	err = s.waitForVirtualMachine(ui, vm)
+	err = s.waitForVirtualMachine(ctx, ui, vm)
 	if err != nil {
 		err = fmt.Errorf("failed to wait to be in a 'Ready' state for Virtual Machine %s/%s: %s", ns, name, err)
 		appContext.Put(common.PackerError, err)
@@ -108,23 +138,139 @@ func (s *StepDeployVM) Run(_ context.Context, state multistep.StateBag) multiste
 	return multistep.ActionContinue
 }
 
-func (s *StepDeployVM) waitForVirtualMachine(ui packer.Ui, vm *kubevirtv1.VirtualMachine) error {
-	watchFunc := func(event watch.Event) (bool, error) {
-		vm, ok := event.Object.(*kubevirtv1.VirtualMachine)
-		if !ok {
-			return false, fmt.Errorf("unexpected type for %v", event.Object)
+// resolveConflicts applies the conflict policy to the Data Volumes, which are created by KubeVirt from the VM templates,
+// and to the names of all the resources when the 'suffix' policy is used. Other resources are handled at creation.
+func (s *StepDeployVM) resolveConflicts(ctx context.Context, ui packer.Ui, buildId string) error {
+	ns := s.VmOptions.Namespace
+	names := generator.BuildResourceNames(s.VmOptions.Name, s.VmOptions.OsFamily)
+	dataVolumeOperations := k8s.DataVolumeOperations(s.KubeClient, ns)
+
+	switch s.ConflictPolicy {
+	case common.ConflictPolicyRecreate:
+		for _, dataVolumeName := range names.DataVolumes {
+			err := k8s.DeleteResourceAndWait(ctx, dataVolumeOperations, ns, dataVolumeName, k8s.ResourceDeletionTimeout)
+			if err != nil {
+				return err
+			}
 		}
-		for index, condition := range vm.Status.Conditions {
-			if condition.Type == kubevirtv1.VirtualMachineReady && condition.Status == corev1.ConditionTrue {
-				return true, nil
-			} else if index == len(vm.Status.Conditions)-1 {
-				ui.Message(fmt.Sprintf("condition '%s' is '%s'", condition.Type, condition.Status))
-				ui.Message(fmt.Sprintf("message: %s", vm.Status.Conditions[index].Message))
+	case common.ConflictPolicyFail:
+		for _, dataVolumeName := range names.DataVolumes {
+			exists, err := k8s.ResourceExists(ctx, dataVolumeOperations, dataVolumeName)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return fmt.Errorf("Data Volume %s/%s already exists, set 'conflict_policy' or run Packer with '-force' to replace it", ns, dataVolumeName)
 			}
 		}
-		return false, nil
+	case common.ConflictPolicySuffix:
+		conflict, err := s.anyResourceExists(ctx, names)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			s.VmOptions.Name = generator.BuildUniqueName(s.VmOptions.Name, buildId)
+			ui.Say(fmt.Sprintf("resources named after %s/%s already exist, using %s/%s instead", ns, names.VirtualMachine, ns, s.VmOptions.Name))
+		}
+	}
+
+	return nil
+}
+
+func (s *StepDeployVM) anyResourceExists(ctx context.Context, names generator.ResourceNames) (bool, error) {
+	ns := s.VmOptions.Namespace
+	checks := []func() (bool, error){
+		func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.VirtualMachineOperations(s.VirtClient, ns), names.VirtualMachine)
+		},
+		func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.VirtualMachineExportOperations(s.VirtClient, ns), names.Export)
+		},
+		func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.ServiceOperations(s.VirtClient, ns), names.Service)
+		},
 	}
-	_, err := k8s.WaitForResource(s.VirtClient.RestClient(), vm.Namespace, k8s.VirtualMachineResourceName, vm.Name, vm.ResourceVersion, s.VmDeploymentTimeOut, watchFunc)
+	for _, name := range names.Secrets {
+		checks = append(checks, func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.SecretOperations(s.VirtClient, ns), name)
+		})
+	}
+	for _, name := range names.DataVolumes {
+		checks = append(checks, func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.DataVolumeOperations(s.KubeClient, ns), name)
+		})
+	}
+	for _, name := range names.Jobs {
+		checks = append(checks, func() (bool, error) {
+			return k8s.ResourceExists(ctx, k8s.JobOperations(s.VirtClient, ns), name)
+		})
+	}
+
+	for _, check := range checks {
+		exists, err := check()
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+func (s *StepDeployVM) waitForVirtualMachine(ctx context.Context, ui packer.Ui, vm *kubevirtv1.VirtualMachine) error {
+	// Without a readiness probe, the VM is ready as soon as the guest boots, the guest agent condition is mirrored on the VM
+	readyCondition := kubevirtv1.VirtualMachineReady
+	if s.VmOptions.Readiness.Strategy == common.ReadyStrategyAgent {
+		readyCondition = kubevirtv1.VirtualMachineConditionType(kubevirtv1.VirtualMachineInstanceAgentConnected)
+	}
+
+	// The import progress and the terminal states of the import or of the launcher pod are not reported by the VM
+	dataVolumes := generator.BuildResourceNames(vm.Name, s.VmOptions.OsFamily).DataVolumes
+	detector := k8s.DeploymentFailureDetector{
+		VirtClient:     s.VirtClient,
+		KubeClient:     s.KubeClient,
+		Namespace:      vm.Namespace,
+		VirtualMachine: vm.Name,
+		DataVolumes:    dataVolumes,
+	}
+	progress := k8s.ImportProgressReporter{
+		KubeClient:  s.KubeClient,
+		Ui:          ui,
+		Namespace:   vm.Namespace,
+		DataVolumes: dataVolumes,
+	}
+
+	_, err := k8s.WaitFor(ctx, k8s.WaitOptions[*kubevirtv1.VirtualMachine]{
+		Object:    &kubevirtv1.VirtualMachine{},
+		ListWatch: k8s.VirtualMachineListWatch(ctx, s.VirtClient, vm.Namespace, vm.Name),
+		Timeout:   s.VmDeploymentTimeOut,
+		Ui:        ui,
+		Progress: func(vm *kubevirtv1.VirtualMachine) string {
+			if len(vm.Status.Conditions) == 0 {
+				return ""
+			}
+			condition := vm.Status.Conditions[len(vm.Status.Conditions)-1]
+			return fmt.Sprintf("condition '%s' is '%s', message: %s", condition.Type, condition.Status, condition.Message)
+		},
+		ProgressInterval: k8s.DefaultProgressInterval,
+		Check: func(ctx context.Context) error {
+			progress.Report(ctx)
+			return detector.Check(ctx)
+		},
+		CheckInterval: failureCheckInterval,
+		Done: func(vm *kubevirtv1.VirtualMachine, exists bool) (bool, error) {
+			if !exists {
+				return false, fmt.Errorf("Virtual Machine has been deleted")
+			}
+			if s.SkipReadyWait && vm.Status.Created {
+				return true, nil
+			}
+			for _, condition := range vm.Status.Conditions {
+				if condition.Type == readyCondition && condition.Status == corev1.ConditionTrue {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	})
 	if err != nil {
 		return fmt.Errorf("failed to wait for Virtual Machine %s/%s to be ready: %s", vm.Namespace, vm.Name, err)
 	}
@@ -136,12 +282,24 @@ func (s *StepDeployVM) waitForVirtualMachine(ui packer.Ui, vm *kubevirtv1.Virtua
 func (s *StepDeployVM) Cleanup(state multistep.StateBag) {
 	appContext := &common.AppContext{State: state}
 	vm := appContext.GetVirtualMachine()
-	if appContext.GetVirtualMachine() == nil {
+	if vm == nil {
+		return
+	}
+	s.collectDiagnostics(state, vm)
+	if s.reused {
+		appContext.GetPackerUi().Message(fmt.Sprintf("keeping Virtual Machine %s/%s, it existed before the build", vm.Namespace, vm.Name))
+		return
+	}
+	if _, halted := state.GetOk(multistep.StateHalted); halted && s.KeepOnError {
+		// The secrets are owned by the VM, they are kept along with it
+		appContext.GetPackerUi().Message(fmt.Sprintf("keeping Virtual Machine %s/%s after the error", vm.Namespace, vm.Name))
 		return
 	}
 
+	ctx, cancel := k8s.CleanupContext()
+	defer cancel()
 	propagationPolicy := metav1.DeletePropagationForeground
-	_ = s.VirtClient.VirtualMachine(vm.Namespace).Delete(context.TODO(), vm.Name, metav1.DeleteOptions{
+	_ = s.VirtClient.VirtualMachine(vm.Namespace).Delete(ctx, vm.Name, metav1.DeleteOptions{
 		PropagationPolicy: &propagationPolicy,
 	})
 	appContext.GetPackerUi().Message(fmt.Sprintf("Virtual Machine %s/%s has been deleted", vm.Namespace, vm.Name))
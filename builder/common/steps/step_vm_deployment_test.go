@@ -0,0 +1,108 @@
+package steps
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"packer-plugin-kubevirt/builder/common/vm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestResolveConflictsSuffix(t *testing.T) {
+	existing := map[string]struct {
+		virt []runtime.Object
+		kube []client.Object
+	}{
+		"secret":      {virt: []runtime.Object{&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-startup-scripts", Namespace: "packer"}}}},
+		"job":         {virt: []runtime.Object{&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-qemu-img-conversion", Namespace: "packer"}}}},
+		"data volume": {kube: []client.Object{&cdiv1beta1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-source", Namespace: "packer"}}}},
+	}
+	for resource, objects := range existing {
+		t.Run(resource, func(t *testing.T) {
+			step := &StepDeployVM{
+				VirtClient:     fake.NewVirtClient(objects.virt...),
+				KubeClient:     fake.NewKubeClient(objects.kube...),
+				VmOptions:      generator.VirtualMachineOptions{Name: "ubuntu", Namespace: "packer", OsFamily: vm.Linux},
+				ConflictPolicy: common.ConflictPolicySuffix,
+			}
+			err := step.resolveConflicts(context.TODO(), new(packersdk.MockUi), "a1b2c3")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if step.VmOptions.Name != generator.BuildUniqueName("ubuntu", "a1b2c3") {
+				t.Fatalf("expected the name to carry the build ID, got %s", step.VmOptions.Name)
+			}
+		})
+	}
+}
+
+func TestResolveConflictsSuffixWithoutConflict(t *testing.T) {
+	step := &StepDeployVM{
+		VirtClient: fake.NewVirtClient(
+			// Resources of another template sharing the namespace are not conflicts
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "debian-startup-scripts", Namespace: "packer"}},
+		),
+		KubeClient:     fake.NewKubeClient(),
+		VmOptions:      generator.VirtualMachineOptions{Name: "ubuntu", Namespace: "packer", OsFamily: vm.Linux},
+		ConflictPolicy: common.ConflictPolicySuffix,
+	}
+	err := step.resolveConflicts(context.TODO(), new(packersdk.MockUi), "a1b2c3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if step.VmOptions.Name != "ubuntu" {
+		t.Fatalf("expected the name to be kept, got %s", step.VmOptions.Name)
+	}
+}
+
+func TestResolveConflictsFail(t *testing.T) {
+	step := &StepDeployVM{
+		VirtClient:     fake.NewVirtClient(),
+		KubeClient:     fake.NewKubeClient(&cdiv1beta1.DataVolume{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu-source", Namespace: "packer"}}),
+		VmOptions:      generator.VirtualMachineOptions{Name: "ubuntu", Namespace: "packer", OsFamily: vm.Linux},
+		ConflictPolicy: common.ConflictPolicyFail,
+	}
+	err := step.resolveConflicts(context.TODO(), new(packersdk.MockUi), "a1b2c3")
+	if err == nil {
+		t.Fatal("expected an error for the existing Data Volume")
+	}
+}
+
+func TestDeployCleanupCollectsDiagnosticsBeforeDeletion(t *testing.T) {
+	virtualMachine := buildVirtualMachine()
+	dir := t.TempDir()
+	step := &StepDeployVM{
+		VirtClient:     fake.NewVirtClient(virtualMachine),
+		KubeClient:     fake.NewKubeClient(),
+		VmOptions:      generator.VirtualMachineOptions{Name: "ubuntu", Namespace: "packer", OsFamily: vm.Linux},
+		DiagnosticsDir: dir,
+	}
+	state := new(multistep.BasicStateBag)
+	appContext := &common.AppContext{State: state}
+	appContext.Put(common.PackerUi, new(packersdk.MockUi))
+	appContext.Put(common.VirtualMachine, virtualMachine)
+	// The deployment itself failed, no later step ran
+	state.Put(multistep.StateHalted, true)
+
+	step.Cleanup(state)
+
+	bundles, err := filepath.Glob(filepath.Join(dir, "ubuntu-*", "virtualmachine.yaml"))
+	if err != nil || len(bundles) != 1 {
+		t.Fatalf("expected a bundle holding the Virtual Machine, got %v (%v)", bundles, err)
+	}
+	if _, err := step.VirtClient.VirtualMachine("packer").Get(context.TODO(), "ubuntu", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("expected the Virtual Machine to be deleted after the collection, got %v", err)
+	}
+}
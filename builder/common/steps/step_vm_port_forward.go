@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
-	kubevirtv1 "kubevirt.io/api/core/v1"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 	"kubevirt.io/client-go/kubecli"
+	"net"
 	"packer-plugin-kubevirt/builder/common"
 	"packer-plugin-kubevirt/builder/common/k8s"
 )
@@ -16,14 +15,22 @@ import (
 type StepPortForwardVM struct {
 	VirtClient kubecli.KubevirtClient
 	Comm       communicator.Config
-	stopChan   chan struct{}
+	Transport  common.PortForwardTransport
+	// ExtraPorts are forwarded along with the communicator port, in the 'remote' or 'local:remote' format
+	ExtraPorts []string
+	forwarder  *k8s.PortForwarder
+}
+
+type forwardedPort struct {
+	local  int
+	remote int
 }
 
 func (s *StepPortForwardVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	appContext := &common.AppContext{State: state}
 	ui := appContext.GetPackerUi()
 
-	portMappings, err := s.computePortMappings()
+	communicatorPort, extraPorts, listeners, err := s.computePortMappings()
 	if err != nil {
 		appContext.Put(common.PackerError, err)
 		ui.Error(err.Error())
@@ -31,21 +38,22 @@ func (s *StepPortForwardVM) Run(ctx context.Context, state multistep.StateBag) m
 		return multistep.ActionHalt
 	}
 
-	vm := appContext.GetVirtualMachine()
-	pods, err := s.VirtClient.CoreV1().Pods(vm.Namespace).List(ctx, v1.ListOptions{
-		LabelSelector: labels.SelectorFromSet(map[string]string{
-			kubevirtv1.VirtualMachineNameLabel: vm.Name,
-		}).String(),
-	})
-	if err != nil || len(pods.Items) < 1 {
-		err := fmt.Errorf("failed to get pod name for port-forwarding Virtual Machine %s/%s: %w", vm.Namespace, vm.Name, err)
-		appContext.Put(common.PackerError, err)
-		ui.Error(err.Error())
-
-		return multistep.ActionHalt
+	var portMappings []string
+	for _, port := range append([]forwardedPort{communicatorPort}, extraPorts...) {
+		portMappings = append(portMappings, fmt.Sprintf("%d:%d", port.local, port.remote))
 	}
 
-	stopChan, err := k8s.RunAsyncPortForward(s.VirtClient, pods.Items[0].Name, vm.Namespace, portMappings)
+	vm := appContext.GetVirtualMachine()
+	forwarder := &k8s.PortForwarder{
+		Client:         s.VirtClient,
+		Ui:             ui,
+		Namespace:      vm.Namespace,
+		VirtualMachine: vm.Name,
+		Ports:          portMappings,
+		Transport:      s.Transport,
+		Listeners:      listeners,
+	}
+	err = forwarder.Start(ctx)
 	if err != nil {
 		err := fmt.Errorf("failed to port-forward Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
 		appContext.Put(common.PackerError, err)
@@ -53,30 +61,90 @@ func (s *StepPortForwardVM) Run(ctx context.Context, state multistep.StateBag) m
 
 		return multistep.ActionHalt
 	}
-	s.stopChan = stopChan
+	s.forwarder = forwarder
+	appContext.Put(common.CommunicatorHost, common.VirtualMachineHost)
+	appContext.Put(common.CommunicatorPort, communicatorPort.local)
 
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	putCommunicatorGeneratedData(generatedData, s.Comm, common.VirtualMachineHost, communicatorPort.local)
+	for _, port := range extraPorts {
+		generatedData.Put(common.LocalPortGeneratedDataKey(port.remote), port.local)
+	}
+
+	for _, mapping := range portMappings {
+		ui.Message(fmt.Sprintf("forwarding %s:%s", common.VirtualMachineHost, mapping))
+	}
 	ui.Say(fmt.Sprintf("port-forwarding step has completed for Virtual Machine %s/%s", vm.Namespace, vm.Name))
 
 	return multistep.ActionContinue
 }
 
-func (s *StepPortForwardVM) computePortMappings() ([]string, error) {
-	var portMapping string
+// computePortMappings returns the communicator port and the extra ports, local ports left unset are allocated and
+// returned with their listener still open, so that no other process takes them before the forwarder starts
+func (s *StepPortForwardVM) computePortMappings() (forwardedPort, []forwardedPort, map[int]net.Listener, error) {
+	var communicatorPort forwardedPort
 	switch s.Comm.Type {
 	case "ssh":
-		portMapping = fmt.Sprintf("%d:%d", common.GetOrDefault(s.Comm.SSHPort, common.DefaultSSHPort), common.DefaultSSHPort)
+		communicatorPort = forwardedPort{local: s.Comm.SSHPort, remote: common.DefaultSSHPort}
 	case "winrm":
 		// NOTE: sysprep has the current DefaultWinRMPort value hardcoded, please change that value carefully while the sysprep conf. is not templated.
-		portMapping = fmt.Sprintf("%d:%d", common.GetOrDefault(s.Comm.WinRMPort, common.DefaultWinRMPort), common.DefaultWinRMPort)
+		communicatorPort = forwardedPort{local: s.Comm.WinRMPort, remote: common.DefaultWinRMPort}
 	default:
-		return nil, fmt.Errorf("unsupported communicator type, allowed values: 'ssh', 'winrm'")
+		return forwardedPort{}, nil, nil, fmt.Errorf("unsupported communicator type, allowed values: 'ssh', 'winrm'")
 	}
 
-	return []string{portMapping}, nil
+	extraPorts := make([]forwardedPort, 0, len(s.ExtraPorts))
+	for _, extraPort := range s.ExtraPorts {
+		local, remote, err := common.ParsePortForward(extraPort)
+		if err != nil {
+			return forwardedPort{}, nil, nil, err
+		}
+		extraPorts = append(extraPorts, forwardedPort{local: local, remote: remote})
+	}
+
+	listeners := make(map[int]net.Listener)
+	allocate := func(port *forwardedPort) error {
+		if port.local != 0 {
+			return nil
+		}
+		listener, local, err := common.AllocateLocalPort()
+		if err != nil {
+			return err
+		}
+		listeners[local] = listener
+		port.local = local
+		return nil
+	}
+	err := allocate(&communicatorPort)
+	for i := range extraPorts {
+		if err == nil {
+			err = allocate(&extraPorts[i])
+		}
+	}
+	if err != nil {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		return forwardedPort{}, nil, nil, err
+	}
+
+	return communicatorPort, extraPorts, listeners, nil
 }
 
 func (s *StepPortForwardVM) Cleanup(_ multistep.StateBag) {
-	if s.stopChan != nil {
-		close(s.stopChan)
+	if s.forwarder != nil {
+		s.forwarder.Stop()
+	}
+}
+
+// putCommunicatorGeneratedData exposes the address of the communicator, e.g. 'build.SSHPort' for Ansible or InSpec
+func putCommunicatorGeneratedData(generatedData *packerbuilderdata.GeneratedData, comm communicator.Config, host string, port int) {
+	generatedData.Put("Host", host)
+	generatedData.Put("Port", port)
+	switch comm.Type {
+	case "ssh":
+		generatedData.Put(common.SSHPortGeneratedDataKey, port)
+	case "winrm":
+		generatedData.Put(common.WinRMPortGeneratedDataKey, port)
 	}
 }
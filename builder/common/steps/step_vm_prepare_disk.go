@@ -0,0 +1,98 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	vmctx "packer-plugin-kubevirt/builder/common/vm"
+	"time"
+)
+
+// StepPrepareDisk runs the offline Jobs on the disk of the stopped Virtual Machine, before its conversion and export
+type StepPrepareDisk struct {
+	VirtClient     kubecli.KubevirtClient
+	ConflictPolicy common.ConflictPolicy
+	// Generalize runs 'virt-sysprep' on the disk of Linux guests
+	Generalize        bool
+	GeneralizeOptions generator.GeneralizeOptions
+	GeneralizeTimeOut time.Duration
+	// Sparsify discards the unused blocks of the disk after the generalization, with the same Job settings
+	Sparsify        bool
+	SparsifyTimeOut time.Duration
+}
+
+func (s *StepPrepareDisk) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	// The Virtual Machine has been stopped by the shutdown step, its disk is no longer attached
+	osFamily := *appContext.GetVirtualMachineOSFamily()
+	if vmctx.Linux == osFamily && s.Generalize {
+		ui.Say(fmt.Sprintf("generify-ing with 'virt-sysprep' Virtual Machine for export %s/%s...", vm.Namespace, vm.Name))
+
+		err := s.generalize(ctx, ui, vm)
+		if err != nil {
+			err := fmt.Errorf("error with 'libguestfs' job %s/%s: %s", vm.Namespace, vm.Name, err)
+			appContext.Put(common.PackerError, err)
+			ui.Error(err.Error())
+
+			return multistep.ActionHalt
+		}
+	}
+
+	if s.Sparsify {
+		ui.Say(fmt.Sprintf("sparsifying with 'virt-sparsify' the disk of Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+
+		err := s.sparsify(ctx, ui, vm)
+		if err != nil {
+			err := fmt.Errorf("error with 'virt-sparsify' job %s/%s: %s", vm.Namespace, vm.Name, err)
+			appContext.Put(common.PackerError, err)
+			ui.Error(err.Error())
+
+			return multistep.ActionHalt
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+// generalize creates the root password Secret first, the Job would otherwise stay pending on the missing volume
+func (s *StepPrepareDisk) generalize(ctx context.Context, ui packer.Ui, vm *kubevirtv1.VirtualMachine) error {
+	if secret := generator.GenerateRootPasswordSecret(vm, s.GeneralizeOptions); secret != nil {
+		_, err := k8s.CreateResource(ctx, k8s.SecretOperations(s.VirtClient, vm.Namespace), secret, s.ConflictPolicy)
+		if err != nil {
+			return fmt.Errorf("failed to create root password Secret: %w", err)
+		}
+	}
+
+	pvcName := generator.BuildDataVolumeName(vm.Name, generator.SourceDataVolumeSuffix)
+	job := generator.GenerateGuestFSJob(vm, pvcName, s.GeneralizeOptions)
+	job, err := k8s.CreateResource(ctx, k8s.JobOperations(s.VirtClient, vm.Namespace), job, s.ConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+
+	return k8s.WaitForJobCompletion(ctx, s.VirtClient, ui, job, s.GeneralizeTimeOut)
+}
+
+func (s *StepPrepareDisk) sparsify(ctx context.Context, ui packer.Ui, vm *kubevirtv1.VirtualMachine) error {
+	pvcName := generator.BuildDataVolumeName(vm.Name, generator.SourceDataVolumeSuffix)
+	job := generator.GenerateSparsifyJob(vm, pvcName, s.GeneralizeOptions)
+	job, err := k8s.CreateResource(ctx, k8s.JobOperations(s.VirtClient, vm.Namespace), job, s.ConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+
+	return k8s.WaitForJobCompletion(ctx, s.VirtClient, ui, job, s.SparsifyTimeOut)
+}
+
+func (s *StepPrepareDisk) Cleanup(_ multistep.StateBag) {
+	// The Jobs are owned by the Virtual Machine, they are deleted along with it
+}
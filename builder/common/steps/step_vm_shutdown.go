@@ -0,0 +1,192 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"time"
+)
+
+const shutdownPollInterval = 2 * time.Second
+
+// StepShutdownVM stops the guest and waits until its disk is released, so that the Jobs mounting it afterward
+// find a consistent filesystem and no 'ReadWriteOnce' attachment left on another node.
+type StepShutdownVM struct {
+	VirtClient      kubecli.KubevirtClient
+	ShutdownCommand string
+	// ShutdownTimeout bounds the whole step, from the shutdown command until the disk is released
+	ShutdownTimeout time.Duration
+}
+
+func (s *StepShutdownVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+	deadline := time.Now().Add(s.ShutdownTimeout)
+
+	if s.ShutdownCommand != "" {
+		err := s.runShutdownCommand(ctx, ui, state, vm, deadline)
+		if err != nil {
+			err := fmt.Errorf("failed to shut down Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+			appContext.Put(common.PackerError, err)
+			ui.Error(err.Error())
+
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say(fmt.Sprintf("stopping Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	err := stopVirtualMachine(ctx, s.VirtClient, ui, vm, time.Until(deadline))
+	if err != nil {
+		err := fmt.Errorf("failed to stop Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("shutdown step has completed for Virtual Machine %s/%s", vm.Namespace, vm.Name))
+
+	return multistep.ActionContinue
+}
+
+// runShutdownCommand switches the VM to the 'Manual' run strategy first, KubeVirt would restart the guest otherwise
+func (s *StepShutdownVM) runShutdownCommand(ctx context.Context, ui packer.Ui, state multistep.StateBag, vm *kubevirtv1.VirtualMachine, deadline time.Time) error {
+	comm := state.Get("communicator").(packer.Communicator)
+
+	err := setManualRunStrategy(ctx, s.VirtClient, vm)
+	if err != nil {
+		return err
+	}
+
+	ui.Say(fmt.Sprintf("gracefully shutting down Virtual Machine %s/%s with the shutdown command...", vm.Namespace, vm.Name))
+	cmd := &packer.RemoteCmd{Command: s.ShutdownCommand}
+	// The connection may drop before the command returns, only the start of the command is awaited
+	err = comm.Start(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to send the shutdown command: %w", err)
+	}
+
+	err = waitForPowerOff(ctx, s.VirtClient, vm, time.Until(deadline))
+	if err != nil {
+		return fmt.Errorf("failed to wait for the guest to power off after the shutdown command: %w", err)
+	}
+
+	return nil
+}
+
+// setManualRunStrategy keeps the guest powered off once it shuts itself down, KubeVirt would restart it otherwise
+func setManualRunStrategy(ctx context.Context, virtClient kubecli.KubevirtClient, vm *kubevirtv1.VirtualMachine) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"running":null,"runStrategy":"%s"}}`, kubevirtv1.RunStrategyManual))
+	_, err := virtClient.VirtualMachine(vm.Namespace).Patch(ctx, vm.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to set the '%s' run strategy: %w", kubevirtv1.RunStrategyManual, err)
+	}
+	return nil
+}
+
+// waitForPowerOff returns once the instance is in a final phase or gone
+func waitForPowerOff(ctx context.Context, virtClient kubecli.KubevirtClient, vm *kubevirtv1.VirtualMachine, timeout time.Duration) error {
+	_, err := k8s.WaitFor(ctx, k8s.WaitOptions[*kubevirtv1.VirtualMachineInstance]{
+		Object:    &kubevirtv1.VirtualMachineInstance{},
+		ListWatch: k8s.VirtualMachineInstanceListWatch(ctx, virtClient, vm.Namespace, vm.Name),
+		Timeout:   timeout,
+		Done: func(vmi *kubevirtv1.VirtualMachineInstance, exists bool) (bool, error) {
+			return !exists || vmi.IsFinal(), nil
+		},
+	})
+	return err
+}
+
+// stopVirtualMachine removes the instance and waits for its disk to be released within the timeout. Once the guest is
+// down, the instance is final and KubeVirt rejects a stop request under the 'Manual' run strategy, it is deleted
+// directly then. Otherwise, stopping sends an ACPI shutdown.
+func stopVirtualMachine(ctx context.Context, virtClient kubecli.KubevirtClient, ui packer.Ui, vm *kubevirtv1.VirtualMachine, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	vmi, err := virtClient.VirtualMachineInstance(vm.Namespace).Get(ctx, vm.Name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		// Nothing is running anymore, the launcher pod may still hold the disk
+	case err != nil:
+		return fmt.Errorf("failed to get Virtual Machine Instance: %w", err)
+	case vmi.IsFinal():
+		err = virtClient.VirtualMachineInstance(vm.Namespace).Delete(ctx, vm.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the final Virtual Machine Instance: %w", err)
+		}
+	default:
+		err = virtClient.VirtualMachine(vm.Namespace).Stop(ctx, vm.Name, &kubevirtv1.StopOptions{})
+		if k8serrors.IsConflict(err) {
+			// The instance went final in between
+			err = virtClient.VirtualMachineInstance(vm.Namespace).Delete(ctx, vm.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return waitForDiskRelease(ctx, virtClient, ui, vm, deadline)
+}
+
+// waitForDiskRelease waits for the instance to be deleted and for the launcher pods mounting the disk to be gone
+func waitForDiskRelease(ctx context.Context, virtClient kubecli.KubevirtClient, ui packer.Ui, vm *kubevirtv1.VirtualMachine, deadline time.Time) error {
+	pvcName := generator.BuildDataVolumeName(vm.Name, generator.SourceDataVolumeSuffix)
+
+	_, err := k8s.WaitFor(ctx, k8s.WaitOptions[*kubevirtv1.VirtualMachineInstance]{
+		Object:    &kubevirtv1.VirtualMachineInstance{},
+		ListWatch: k8s.VirtualMachineInstanceListWatch(ctx, virtClient, vm.Namespace, vm.Name),
+		Timeout:   time.Until(deadline),
+		Done: func(_ *kubevirtv1.VirtualMachineInstance, exists bool) (bool, error) {
+			return !exists, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ui.Message(fmt.Sprintf("Virtual Machine Instance %s/%s has been deleted", vm.Namespace, vm.Name))
+
+	// The launcher pod outlives the instance for its termination grace period
+	podSelector := labels.SelectorFromSet(map[string]string{kubevirtv1.VirtualMachineNameLabel: vm.Name}).String()
+	return wait.PollUntilContextTimeout(ctx, shutdownPollInterval, time.Until(deadline), true, func(ctx context.Context) (bool, error) {
+		pods, err := virtClient.CoreV1().Pods(vm.Namespace).List(ctx, metav1.ListOptions{LabelSelector: podSelector})
+		if err != nil {
+			return false, nil
+		}
+		for _, pod := range pods.Items {
+			if mountsPersistentVolumeClaim(pod, pvcName) {
+				return false, nil
+			}
+		}
+		ui.Message(fmt.Sprintf("Persistent Volume Claim %s/%s has been released", vm.Namespace, pvcName))
+		return true, nil
+	})
+}
+
+// mountsPersistentVolumeClaim leaves out terminated pods, their containers no longer hold the volume
+func mountsPersistentVolumeClaim(pod corev1.Pod, pvcName string) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvcName {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StepShutdownVM) Cleanup(_ multistep.StateBag) {
+	// Nothing to clean up, the Virtual Machine is deleted by the deployment step
+}
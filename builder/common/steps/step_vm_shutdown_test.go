@@ -0,0 +1,133 @@
+package steps
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"packer-plugin-kubevirt/builder/common/k8s/fake"
+)
+
+func TestMountsPersistentVolumeClaim(t *testing.T) {
+	claimVolume := func(name string) corev1.Volume {
+		return corev1.Volume{Name: "disk", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+		}}
+	}
+	pods := map[string]struct {
+		pod    corev1.Pod
+		mounts bool
+	}{
+		"running with the claim": {
+			pod:    corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{claimVolume("ubuntu-source")}}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+			mounts: true,
+		},
+		"pending with the claim": {
+			pod:    corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{claimVolume("ubuntu-source")}}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+			mounts: true,
+		},
+		"running with another claim": {
+			pod: corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{claimVolume("debian-source")}}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		},
+		"succeeded with the claim": {
+			pod: corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{claimVolume("ubuntu-source")}}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+		},
+		"failed with the claim": {
+			pod: corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{claimVolume("ubuntu-source")}}, Status: corev1.PodStatus{Phase: corev1.PodFailed}},
+		},
+		"running without volumes": {
+			pod: corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		},
+	}
+	for name, test := range pods {
+		if mounts := mountsPersistentVolumeClaim(test.pod, "ubuntu-source"); mounts != test.mounts {
+			t.Errorf("%s: expected %t, got %t", name, test.mounts, mounts)
+		}
+	}
+}
+
+func buildVirtualMachine() *kubevirtv1.VirtualMachine {
+	return &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"}}
+}
+
+func buildVirtualMachineInstance(phase kubevirtv1.VirtualMachineInstancePhase) *kubevirtv1.VirtualMachineInstance {
+	return &kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "ubuntu", Namespace: "packer"},
+		Status:     kubevirtv1.VirtualMachineInstanceStatus{Phase: phase},
+	}
+}
+
+func TestStopVirtualMachineDeletesFinalInstance(t *testing.T) {
+	vm := buildVirtualMachine()
+	client := fake.NewVirtClient(vm, buildVirtualMachineInstance(kubevirtv1.Succeeded))
+
+	err := stopVirtualMachine(context.TODO(), client, new(packersdk.MockUi), vm, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stops := client.Stops(); len(stops) > 0 {
+		t.Fatalf("expected the final instance to be deleted without a stop request, got %v", stops)
+	}
+	if _, err := client.VirtualMachineInstance("packer").Get(context.TODO(), "ubuntu", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("expected the instance to be deleted, got %v", err)
+	}
+}
+
+func TestStopVirtualMachineToleratesConflict(t *testing.T) {
+	vm := buildVirtualMachine()
+	client := fake.NewVirtClient(vm, buildVirtualMachineInstance(kubevirtv1.Running))
+	// The guest powered off between the check of the instance and the stop request
+	client.StopError = k8serrors.NewConflict(schema.GroupResource{Group: "subresources.kubevirt.io", Resource: "virtualmachines"}, "ubuntu", nil)
+
+	err := stopVirtualMachine(context.TODO(), client, new(packersdk.MockUi), vm, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stops := client.Stops(); len(stops) != 1 {
+		t.Fatalf("expected a stop request, got %v", stops)
+	}
+}
+
+func TestStopVirtualMachineWaitsForLauncherPod(t *testing.T) {
+	vm := buildVirtualMachine()
+	launcher := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "virt-launcher-ubuntu-x7k2p",
+			Namespace: "packer",
+			Labels:    map[string]string{kubevirtv1.VirtualMachineNameLabel: "ubuntu"},
+		},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "disk", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "ubuntu-source"},
+		}}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	client := fake.NewVirtClient(vm, launcher)
+
+	err := stopVirtualMachine(context.TODO(), client, new(packersdk.MockUi), vm, time.Second)
+	if err == nil {
+		t.Fatal("expected a timeout while the launcher pod holds the disk")
+	}
+}
+
+func TestStopVirtualMachineCancelled(t *testing.T) {
+	vm := buildVirtualMachine()
+	client := fake.NewVirtClient(vm, buildVirtualMachineInstance(kubevirtv1.Running))
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// The instance never stops, only the cancellation ends the wait
+	start := time.Now()
+	err := stopVirtualMachine(ctx, client, new(packersdk.MockUi), vm, 20*time.Minute)
+	if err == nil {
+		t.Fatal("expected an error once the build is cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the cancellation to end the wait promptly, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"strings"
+	"time"
+)
+
+const (
+	unattendPath       = `C:\Windows\Temp\packer-generalize-unattend.xml`
+	sysprepLogsTimeout = 5 * time.Minute
+)
+
+// StepGeneralizeWindows runs sysprep through the communicator once provisioning is done, so that the exported image
+// gets new SIDs and goes through OOBE at its first boot. It replaces the shutdown step, sysprep powers the guest off.
+type StepGeneralizeWindows struct {
+	VirtClient kubecli.KubevirtClient
+	Unattend   string
+	// Timeout bounds the sysprep run, until the guest powers off
+	Timeout         time.Duration
+	ShutdownTimeout time.Duration
+	// Options provide the image and resources of the Job reading the sysprep logs
+	Options        generator.GeneralizeOptions
+	ConflictPolicy common.ConflictPolicy
+}
+
+func (s *StepGeneralizeWindows) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	ui.Say(fmt.Sprintf("generalizing with 'sysprep' Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	err := s.startSysprep(ctx, state, vm)
+	if err != nil {
+		err := fmt.Errorf("failed to start sysprep on Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+	sysprepErr := waitForPowerOff(ctx, s.VirtClient, vm, s.Timeout)
+	if sysprepErr != nil {
+		// The guest is still running, e.g. sysprep waits on an error dialog, the logs are read once it is stopped
+		sysprepErr = fmt.Errorf("the guest did not power off: %w", sysprepErr)
+		ui.Error(fmt.Sprintf("sysprep did not complete on Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, sysprepErr))
+	}
+
+	ui.Say(fmt.Sprintf("stopping Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	err = stopVirtualMachine(ctx, s.VirtClient, ui, vm, s.ShutdownTimeout)
+	if err != nil {
+		err := fmt.Errorf("failed to stop Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("checking the sysprep logs of Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	err = s.checkSysprepLogs(ctx, ui, vm)
+	if err == nil && sysprepErr != nil {
+		err = sysprepErr
+	}
+	if err != nil {
+		err := fmt.Errorf("failed to generalize Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("generalize step has completed for Virtual Machine %s/%s", vm.Namespace, vm.Name))
+
+	return multistep.ActionContinue
+}
+
+// startSysprep switches the VM to the 'Manual' run strategy first, KubeVirt would restart the guest otherwise
+func (s *StepGeneralizeWindows) startSysprep(ctx context.Context, state multistep.StateBag, vm *kubevirtv1.VirtualMachine) error {
+	comm := state.Get("communicator").(packer.Communicator)
+
+	err := setManualRunStrategy(ctx, s.VirtClient, vm)
+	if err != nil {
+		return err
+	}
+
+	err = comm.Upload(unattendPath, strings.NewReader(s.Unattend), nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload the answer file: %w", err)
+	}
+
+	cmd := &packer.RemoteCmd{Command: fmt.Sprintf(`C:\Windows\System32\Sysprep\sysprep.exe /generalize /oobe /shutdown /quiet /unattend:%s`, unattendPath)}
+	// The connection drops once the guest shuts down, only the start of the command is awaited
+	return comm.Start(ctx, cmd)
+}
+
+// checkSysprepLogs reads the image state offline, the guest cannot be queried anymore once generalized
+func (s *StepGeneralizeWindows) checkSysprepLogs(ctx context.Context, ui packer.Ui, vm *kubevirtv1.VirtualMachine) error {
+	pvcName := generator.BuildDataVolumeName(vm.Name, generator.SourceDataVolumeSuffix)
+	job := generator.GenerateSysprepLogsJob(vm, pvcName, s.Options)
+	job, err := k8s.CreateResource(ctx, k8s.JobOperations(s.VirtClient, vm.Namespace), job, s.ConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to create 'libguestfs' Job: %w", err)
+	}
+
+	return k8s.WaitForJobCompletion(ctx, s.VirtClient, ui, job, sysprepLogsTimeout)
+}
+
+func (s *StepGeneralizeWindows) Cleanup(_ multistep.StateBag) {
+	// Nothing to clean up, the Job is owned by the Virtual Machine and deleted along with it
+}
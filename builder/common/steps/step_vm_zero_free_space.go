@@ -0,0 +1,67 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"strings"
+	"time"
+)
+
+const zeroFreeSpaceScriptPath = `C:\Windows\Temp\packer-zero-free-space.ps1`
+
+// StepZeroFreeSpace fills the free space of Windows guests with zeros through the communicator, the deleted data
+// would otherwise end up in the compressed export. The sparsification then discards the zeroed blocks.
+type StepZeroFreeSpace struct {
+	Timeout time.Duration
+}
+
+func (s *StepZeroFreeSpace) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	appContext := &common.AppContext{State: state}
+	ui := appContext.GetPackerUi()
+	vm := appContext.GetVirtualMachine()
+
+	ui.Say(fmt.Sprintf("zeroing the free space of Virtual Machine %s/%s...", vm.Namespace, vm.Name))
+	err := s.zeroFreeSpace(ctx, ui, state)
+	if err != nil {
+		err := fmt.Errorf("failed to zero the free space of Virtual Machine %s/%s: %s", vm.Namespace, vm.Name, err)
+		appContext.Put(common.PackerError, err)
+		ui.Error(err.Error())
+
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepZeroFreeSpace) zeroFreeSpace(ctx context.Context, ui packer.Ui, state multistep.StateBag) error {
+	comm := state.Get("communicator").(packer.Communicator)
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	script, err := generator.ZeroFreeSpaceScript()
+	if err != nil {
+		return err
+	}
+	err = comm.Upload(zeroFreeSpaceScriptPath, strings.NewReader(script), nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload the script: %w", err)
+	}
+
+	cmd := &packer.RemoteCmd{Command: fmt.Sprintf("powershell -NoProfile -ExecutionPolicy Bypass -File %s", zeroFreeSpaceScriptPath)}
+	err = cmd.RunWithUi(ctx, comm, ui)
+	if err != nil {
+		return err
+	}
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("the script exited with code %d", cmd.ExitStatus())
+	}
+	return nil
+}
+
+func (s *StepZeroFreeSpace) Cleanup(_ multistep.StateBag) {
+	// Nothing to clean up, the zero file and the script are deleted by the script
+}
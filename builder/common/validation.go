@@ -0,0 +1,93 @@
+package common
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+func ValidateRequired(field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("%s must be specified", field)
+	}
+	return nil
+}
+
+func ValidateDNS1123Label(field, value string) error {
+	if msgs := validation.IsDNS1123Label(value); len(msgs) > 0 {
+		return fmt.Errorf("%s %q is invalid: %s", field, value, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+func ValidateDNS1123Subdomain(field, value string) error {
+	if msgs := validation.IsDNS1123Subdomain(value); len(msgs) > 0 {
+		return fmt.Errorf("%s %q is invalid: %s", field, value, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+func ValidateDNS1035Label(field, value string) error {
+	if msgs := validation.IsDNS1035Label(value); len(msgs) > 0 {
+		return fmt.Errorf("%s %q is invalid: %s", field, value, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+func ValidateQuantity(field, value string) error {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid Kubernetes quantity: %s", field, value, err)
+	}
+	if quantity.Sign() <= 0 {
+		return fmt.Errorf("%s %q must be greater than zero", field, value)
+	}
+	return nil
+}
+
+func ValidateURL(field, value string, schemes ...string) error {
+	parsedUrl, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid URL: %s", field, value, err)
+	}
+	if !slices.Contains(schemes, strings.ToLower(parsedUrl.Scheme)) {
+		return fmt.Errorf("%s %q has an unsupported scheme, allowed values: '%s'", field, value, strings.Join(schemes, "', '"))
+	}
+	if parsedUrl.Host == "" {
+		return fmt.Errorf("%s %q is missing a host", field, value)
+	}
+	return nil
+}
+
+// ValidateTimeout checks the bound of a wait, zero would fail it right away
+func ValidateTimeout(field string, value time.Duration) error {
+	if value <= 0 {
+		return fmt.Errorf("%s must be greater than zero, got '%s'", field, value)
+	}
+	return nil
+}
+
+// ValidateDuration checks a duration where zero is meaningful, e.g. a disabled TTL or no delay
+func ValidateDuration(field string, value time.Duration) error {
+	if value < 0 {
+		return fmt.Errorf("%s must not be negative, got '%s'", field, value)
+	}
+	return nil
+}
+
+// ValidateOneOf checks the value of an enumerated setting, the error lists the allowed values
+func ValidateOneOf[T ~string](field string, value T, allowed []T) error {
+	if slices.Contains(allowed, value) {
+		return nil
+	}
+
+	values := make([]string, len(allowed))
+	for i, allowedValue := range allowed {
+		values[i] = string(allowedValue)
+	}
+	return fmt.Errorf("unsupported %s '%s', allowed values: '%s'", field, value, strings.Join(values, "', '"))
+}
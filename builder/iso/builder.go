@@ -1,8 +1,9 @@
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,GeneralizeConfig
 
 package iso
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,19 +13,23 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/shutdowncommand"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	gossh "golang.org/x/crypto/ssh"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/yaml"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	instancetypev1beta1 "kubevirt.io/api/instancetype/v1beta1"
 	"kubevirt.io/client-go/kubecli"
-	"log"
+	cdiv1beta1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+	"maps"
 	buildercommon "packer-plugin-kubevirt/builder/common"
 	"packer-plugin-kubevirt/builder/common/k8s"
 	"packer-plugin-kubevirt/builder/common/k8s/generator"
 	stepDef "packer-plugin-kubevirt/builder/common/steps"
 	"packer-plugin-kubevirt/builder/common/vm"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -36,8 +41,14 @@ const (
 type Config struct {
 	common.PackerConfig             `mapstructure:",squash"`
 	Comm                            communicator.Config `mapstructure:",squash"`
+	shutdowncommand.ShutdownConfig  `mapstructure:",squash"`
 	KubernetesName                  string              `mapstructure:"kubernetes_name"`
+	KubernetesNameUnique            bool                `mapstructure:"kubernetes_name_unique" required:"false"`
 	KubernetesNamespace             string              `mapstructure:"kubernetes_namespace"`
+	KubernetesNamespaceEphemeral    bool                `mapstructure:"kubernetes_namespace_ephemeral" required:"false"`
+	KubernetesNamespaceQuota        map[string]string   `mapstructure:"kubernetes_namespace_quota" required:"false"`
+	KubernetesNamespaceTTL          time.Duration       `mapstructure:"kubernetes_namespace_ttl" required:"false"`
+	KubernetesNamespaceTeardown     bool                `mapstructure:"kubernetes_namespace_teardown" required:"false"`
 	KubernetesNodeSelectors         map[string]string   `mapstructure:"kubernetes_node_selectors"`
 	KubernetesTolerations           []map[string]string `mapstructure:"kubernetes_tolerations"`
 	KubevirtOsPreference            string              `mapstructure:"kubevirt_os_preference"`
@@ -49,13 +60,35 @@ type Config struct {
 	VirtualMachineExportTimeOut     time.Duration       `mapstructure:"vm_export_timeout" required:"false"`
 	VirtualMachineLinuxCloudInit    string              `mapstructure:"vm_linux_cloud_init" required:"false"`
 	VirtualMachineWindowsSysprep    string              `mapstructure:"vm_windows_sysprep" required:"false"`
+	OrphanCleanupTTL                time.Duration       `mapstructure:"orphan_cleanup_ttl" required:"false"`
+	OrphanCleanupNamespaces         []string            `mapstructure:"orphan_cleanup_namespaces" required:"false"`
+	ConflictPolicy                  string              `mapstructure:"conflict_policy" required:"false"`
+	KeepVirtualMachineOnError       bool                `mapstructure:"keep_vm_on_error" required:"false"`
+	CompletionSignal                string              `mapstructure:"vm_completion_signal" required:"false"`
+	CompletionCommand               []string            `mapstructure:"vm_completion_command" required:"false"`
+	CompletionTimeOut               time.Duration       `mapstructure:"vm_completion_timeout" required:"false"`
+	PortForwardTransport            string              `mapstructure:"port_forward_transport" required:"false"`
+	ConnectivityMode                string              `mapstructure:"connectivity_mode" required:"false"`
+	ExtraPortForwards               []string            `mapstructure:"extra_port_forwards" required:"false"`
+	ReadyStrategy                   string              `mapstructure:"vm_ready_strategy" required:"false"`
+	ReadyCommand                    []string            `mapstructure:"vm_ready_command" required:"false"`
+	ReadyInitialDelay               time.Duration       `mapstructure:"vm_ready_initial_delay" required:"false"`
+	ReadyPeriod                     time.Duration       `mapstructure:"vm_ready_period" required:"false"`
+	ReadyTimeout                    time.Duration       `mapstructure:"vm_ready_timeout" required:"false"`
+	DiagnosticsDir                  string              `mapstructure:"diagnostics_dir" required:"false"`
+	Generalize                      GeneralizeConfig    `mapstructure:"generalize" required:"false"`
+	Sparsify                        bool                `mapstructure:"sparsify" required:"false"`
+	SparsifyTimeOut                 time.Duration       `mapstructure:"sparsify_timeout" required:"false"`
+	OutputFormat                    string              `mapstructure:"output_format" required:"false"`
+	ConversionTimeOut               time.Duration       `mapstructure:"conversion_timeout" required:"false"`
 }
 
 type Builder struct {
-	config     Config
-	runner     multistep.Runner
-	virtClient kubecli.KubevirtClient
-	kubeClient client.Client
+	config      Config
+	runner      multistep.Runner
+	virtClient  kubecli.KubevirtClient
+	kubeClient  client.Client
+	tolerations []v1.Toleration
 }
 
 func (b *Builder) ConfigSpec() hcldec.ObjectSpec {
@@ -85,19 +118,98 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 		b.config.Comm.Type = "ssh"
 		warnings = append(warnings, "no communication method was specified, so SSH will be used by default to connect to the machine.")
 	}
+	// Local ports left unset are allocated at build time, see 'StepPortForwardVM'
 	commType := strings.ToLower(b.config.Comm.Type)
-	if commType == "ssh" && b.config.Comm.SSHPort == 0 {
-		b.config.Comm.SSHPort = 2222
+	switch commType {
+	case "ssh":
+		generatedVars = append(generatedVars, buildercommon.SSHPortGeneratedDataKey)
+	case "winrm":
+		generatedVars = append(generatedVars, buildercommon.WinRMPortGeneratedDataKey)
 	}
-	if commType == "winrm" && b.config.Comm.WinRMPort == 0 {
-		b.config.Comm.WinRMPort = 5389
-	}
-	if buildercommon.IsReservedPort(b.config.Comm.SSHPort) || buildercommon.IsReservedPort(b.config.Comm.WinRMPort) {
-		return nil, nil, fmt.Errorf("the local port for communicating with the remote machine is reserved - please use a port above 1024")
+	for _, extraPort := range b.config.ExtraPortForwards {
+		if _, remotePort, err := buildercommon.ParsePortForward(extraPort); err == nil {
+			generatedVars = append(generatedVars, buildercommon.LocalPortGeneratedDataKey(remotePort))
+		}
 	}
 	if b.config.Comm.WinRMTimeout == 0 {
 		b.config.Comm.WinRMTimeout = 30 * time.Second
 	}
+	if b.config.ConnectivityMode == "" {
+		b.config.ConnectivityMode = string(buildercommon.ConnectivityModePortForward)
+	}
+	if b.config.PortForwardTransport == "" {
+		b.config.PortForwardTransport = string(buildercommon.PortForwardTransportVMI)
+	}
+	if b.config.ReadyStrategy == "" {
+		b.config.ReadyStrategy = string(buildercommon.DefaultReadyStrategy(vm.GetOSFamily(b.config.KubevirtOsPreference)))
+	}
+	if b.config.ReadyInitialDelay == 0 {
+		b.config.ReadyInitialDelay = 30 * time.Second
+	}
+	if b.config.ReadyPeriod == 0 {
+		b.config.ReadyPeriod = 10 * time.Second
+	}
+	if b.config.ReadyTimeout == 0 {
+		b.config.ReadyTimeout = 5 * time.Second
+		if b.config.ReadyStrategy == string(buildercommon.ReadyStrategyCloudInit) {
+			// 'cloud-init status --wait' blocks until the end of the boot, each attempt waits as long as possible
+			b.config.ReadyTimeout = b.config.ReadyPeriod
+		}
+	}
+	if commType == "none" {
+		if b.config.CompletionSignal == "" {
+			b.config.CompletionSignal = string(buildercommon.CompletionSignalPowerOff)
+		}
+		if len(b.config.CompletionCommand) == 0 && vm.GetOSFamily(b.config.KubevirtOsPreference) == vm.Linux {
+			b.config.CompletionCommand = buildercommon.DefaultCompletionCommand
+		}
+		if b.config.CompletionTimeOut == 0 {
+			b.config.CompletionTimeOut = 30 * time.Minute
+		}
+	}
+
+	if b.config.ShutdownTimeout == 0 {
+		b.config.ShutdownTimeout = 5 * time.Minute
+	}
+
+	if b.config.KubernetesNamespaceEphemeral && len(b.config.KubernetesNamespaceQuota) == 0 {
+		b.config.KubernetesNamespaceQuota = maps.Clone(generator.DefaultNamespaceQuota)
+	}
+	if b.config.KubernetesNamespaceEphemeral && b.config.KubernetesNamespaceTTL == 0 {
+		b.config.KubernetesNamespaceTTL = 24 * time.Hour
+	}
+
+	if b.config.ConflictPolicy == "" {
+		b.config.ConflictPolicy = string(buildercommon.ConflictPolicyFail)
+	}
+	if b.config.PackerForce && b.config.ConflictPolicy != string(buildercommon.ConflictPolicyRecreate) {
+		b.config.ConflictPolicy = string(buildercommon.ConflictPolicyRecreate)
+		warnings = append(warnings, "'-force' is set, existing resources will be deleted and recreated regardless of 'conflict_policy'.")
+	}
+
+	b.config.Generalize.prepare(vm.GetOSFamily(b.config.KubevirtOsPreference), commType)
+	if b.config.SparsifyTimeOut == 0 {
+		b.config.SparsifyTimeOut = 15 * time.Minute
+	}
+	if b.config.ConversionTimeOut == 0 {
+		b.config.ConversionTimeOut = 15 * time.Minute
+	}
+
+	if len(b.config.OrphanCleanupNamespaces) == 0 {
+		b.config.OrphanCleanupNamespaces = []string{b.config.KubernetesNamespace}
+	}
+
+	var errs *packer.MultiError
+	errs = packer.MultiErrorAppend(errs, b.config.validate()...)
+
+	b.tolerations, err = decodeTolerations(b.config.KubernetesTolerations)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+
+	if len(errs.Errors) > 0 {
+		return nil, warnings, errs
+	}
 
 	b.virtClient, err = k8s.GetKubevirtClient()
 	if err != nil {
@@ -107,6 +219,8 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 	scheme := runtime.NewScheme()
 	builders := []runtime.SchemeBuilder{
 		// Add your `SchemeBuilder` containing CRDs (if needed)
+		cdiv1beta1.SchemeBuilder,
+		instancetypev1beta1.SchemeBuilder,
 	}
 	for _, builder := range builders {
 		err = builder.AddToScheme(scheme)
@@ -124,19 +238,255 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 	return generatedVars, warnings, nil
 }
 
-func decodeTolerations(rawTolerations []map[string]string) []v1.Toleration {
+func (c *Config) validate() []error {
+	var errs []error
+
+	osFamily := vm.GetOSFamily(c.KubevirtOsPreference)
+	if err := buildercommon.ValidateRequired("kubernetes_name", c.KubernetesName); err != nil {
+		errs = append(errs, err)
+	} else {
+		resourceName := c.KubernetesName
+		if c.KubernetesNameUnique || c.ConflictPolicy == string(buildercommon.ConflictPolicySuffix) {
+			// Names have to fit the build ID suffix that may be appended at build time
+			resourceName = generator.BuildUniqueName(c.KubernetesName, strings.Repeat("x", buildercommon.BuildIdLength))
+		}
+		errs = append(errs, generator.ValidateResourceNames(resourceName, osFamily)...)
+	}
+
+	if err := buildercommon.ValidateRequired("kubernetes_namespace", c.KubernetesNamespace); err != nil {
+		errs = append(errs, err)
+	} else if c.KubernetesNamespaceEphemeral {
+		// The namespace is used as a prefix, the build ID is appended at build time
+		namespace := generator.BuildUniqueName(c.KubernetesNamespace, strings.Repeat("x", buildercommon.BuildIdLength))
+		if err := buildercommon.ValidateDNS1123Label("kubernetes_namespace", namespace); err != nil {
+			errs = append(errs, err)
+		}
+	} else if err := buildercommon.ValidateDNS1123Label("kubernetes_namespace", c.KubernetesNamespace); err != nil {
+		errs = append(errs, err)
+	}
+	if c.KubernetesNamespaceEphemeral {
+		if err := buildercommon.ValidateTimeout("kubernetes_namespace_ttl", c.KubernetesNamespaceTTL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.KubernetesNamespaceTeardown && !c.KubernetesNamespaceEphemeral {
+		errs = append(errs, fmt.Errorf("kubernetes_namespace_teardown requires kubernetes_namespace_ephemeral"))
+	}
+	for name, quantity := range c.KubernetesNamespaceQuota {
+		if err := buildercommon.ValidateQuantity(fmt.Sprintf("kubernetes_namespace_quota[%s]", name), quantity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := buildercommon.ValidateRequired("kubevirt_os_preference", c.KubevirtOsPreference); err != nil {
+		errs = append(errs, err)
+	}
+
+	sourceSchemes := []string{"http", "https"}
+	if c.SourceAWSAccessKeyId != "" || c.SourceAWSSecretAccessKey != "" {
+		if c.SourceAWSAccessKeyId == "" || c.SourceAWSSecretAccessKey == "" {
+			errs = append(errs, fmt.Errorf("source_aws_access_key_id and source_aws_secret_access_key must be provided together"))
+		}
+		sourceSchemes = append(sourceSchemes, "s3")
+	}
+	if err := buildercommon.ValidateRequired("source_url", c.SourceUrl); err != nil {
+		errs = append(errs, err)
+	} else if err := buildercommon.ValidateURL("source_url", c.SourceUrl, sourceSchemes...); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := buildercommon.ValidateRequired("vm_disk_space", c.VirtualMachineDiskSpace); err != nil {
+		errs = append(errs, err)
+	} else if err := buildercommon.ValidateQuantity("vm_disk_space", c.VirtualMachineDiskSpace); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := buildercommon.ValidateTimeout("vm_deployment_timeout", c.VirtualMachineDeploymentTimeOut); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateTimeout("vm_export_timeout", c.VirtualMachineExportTimeOut); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateTimeout("winrm_timeout", c.Comm.WinRMTimeout); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateTimeout("shutdown_timeout", c.ShutdownTimeout); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateDuration("orphan_cleanup_ttl", c.OrphanCleanupTTL); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateConflictPolicy("conflict_policy", buildercommon.ConflictPolicy(c.ConflictPolicy)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.OrphanCleanupTTL > 0 {
+		for index, ns := range c.OrphanCleanupNamespaces {
+			if err := buildercommon.ValidateDNS1123Label(fmt.Sprintf("orphan_cleanup_namespaces[%d]", index), ns); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	commType := strings.ToLower(c.Comm.Type)
+	if commType != "ssh" && commType != "winrm" && commType != "none" {
+		errs = append(errs, fmt.Errorf("unsupported communicator type '%s', allowed values: 'ssh', 'winrm', 'none'", c.Comm.Type))
+	}
+	connectivityMode := buildercommon.ConnectivityMode(c.ConnectivityMode)
+	if err := buildercommon.ValidateConnectivityMode("connectivity_mode", connectivityMode); err != nil {
+		errs = append(errs, err)
+	}
+	if connectivityMode == buildercommon.ConnectivityModeService && c.KubernetesName != "" {
+		// The Service is named after the VM, Service names cannot start with a digit
+		if err := buildercommon.ValidateDNS1035Label("kubernetes_name", c.KubernetesName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := buildercommon.ValidatePortForwardTransport("port_forward_transport", buildercommon.PortForwardTransport(c.PortForwardTransport)); err != nil {
+		errs = append(errs, err)
+	}
+	if commType == "none" {
+		signal := buildercommon.CompletionSignal(c.CompletionSignal)
+		if err := buildercommon.ValidateCompletionSignal("vm_completion_signal", signal); err != nil {
+			errs = append(errs, err)
+		}
+		if signal == buildercommon.CompletionSignalGuestAgent && len(c.CompletionCommand) == 0 {
+			errs = append(errs, fmt.Errorf("vm_completion_command must be specified when vm_completion_signal is '%s'", signal))
+		}
+		if err := buildercommon.ValidateTimeout("vm_completion_timeout", c.CompletionTimeOut); err != nil {
+			errs = append(errs, err)
+		}
+		if c.ShutdownCommand != "" {
+			errs = append(errs, fmt.Errorf("shutdown_command requires a communicator, it cannot be used with communicator 'none'"))
+		}
+	}
+	readyStrategy := buildercommon.ReadyStrategy(c.ReadyStrategy)
+	if err := buildercommon.ValidateReadyStrategy("vm_ready_strategy", readyStrategy); err != nil {
+		errs = append(errs, err)
+	}
+	if readyStrategy == buildercommon.ReadyStrategyExec && len(c.ReadyCommand) == 0 {
+		errs = append(errs, fmt.Errorf("vm_ready_command must be specified when vm_ready_strategy is '%s'", readyStrategy))
+	}
+	if readyStrategy == buildercommon.ReadyStrategyTCP && commType != "ssh" && commType != "winrm" {
+		errs = append(errs, fmt.Errorf("vm_ready_strategy '%s' checks the communicator port, it requires communicator 'ssh' or 'winrm'", readyStrategy))
+	}
+	if err := buildercommon.ValidateDuration("vm_ready_initial_delay", c.ReadyInitialDelay); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateTimeout("vm_ready_period", c.ReadyPeriod); err != nil {
+		errs = append(errs, err)
+	}
+	if err := buildercommon.ValidateTimeout("vm_ready_timeout", c.ReadyTimeout); err != nil {
+		errs = append(errs, err)
+	}
+	if buildercommon.IsReservedPort(c.Comm.SSHPort) || buildercommon.IsReservedPort(c.Comm.WinRMPort) {
+		errs = append(errs, fmt.Errorf("the local port for communicating with the remote machine is reserved - please use a port above 1024, or leave it unset to allocate a free one"))
+	}
+	errs = append(errs, c.Generalize.validate()...)
+	if osFamily == vm.Windows && c.Generalize.Enabled != nil && *c.Generalize.Enabled {
+		if commType == "none" {
+			errs = append(errs, fmt.Errorf("generalize requires a communicator on Windows, sysprep is run through it"))
+		}
+		if c.ShutdownCommand != "" {
+			errs = append(errs, fmt.Errorf("shutdown_command cannot be used along with the Windows generalization, sysprep shuts the guest down"))
+		}
+	}
+	if err := buildercommon.ValidateTimeout("sparsify_timeout", c.SparsifyTimeOut); err != nil {
+		errs = append(errs, err)
+	}
+	if c.OutputFormat != "" {
+		if err := generator.ValidateOutputFormat("output_format", generator.OutputFormat(c.OutputFormat)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := buildercommon.ValidateTimeout("conversion_timeout", c.ConversionTimeOut); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.ExtraPortForwards) > 0 && (commType == "none" || connectivityMode != buildercommon.ConnectivityModePortForward) {
+		errs = append(errs, fmt.Errorf("extra_port_forwards requires connectivity_mode '%s' and a communicator", buildercommon.ConnectivityModePortForward))
+	}
+	for index, extraPort := range c.ExtraPortForwards {
+		localPort, _, err := buildercommon.ParsePortForward(extraPort)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("extra_port_forwards[%d] is invalid: %s", index, err))
+		} else if buildercommon.IsReservedPort(localPort) {
+			errs = append(errs, fmt.Errorf("extra_port_forwards[%d] uses a reserved local port - please use a port above 1024, or leave it unset to allocate a free one", index))
+		}
+	}
+
+	return errs
+}
+
+func decodeTolerations(rawTolerations []map[string]string) ([]v1.Toleration, error) {
+	var errs *packer.MultiError
 	var tolerations []v1.Toleration
-	for _, rawToleration := range rawTolerations {
+	for index, rawToleration := range rawTolerations {
 		var toleration v1.Toleration
-		serializedToleration, _ := json.Marshal(rawToleration)
-		reader := strings.NewReader(string(serializedToleration))
-		err := yaml.NewYAMLOrJSONDecoder(reader, 4096).Decode(&toleration)
+		serializedToleration, err := marshalToleration(rawToleration)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("kubernetes_tolerations[%d] is invalid: %s", index, err))
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(serializedToleration))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&toleration)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("kubernetes_tolerations[%d] is invalid: %s", index, err))
+			continue
+		}
+		err = validateToleration(toleration)
 		if err != nil {
-			log.Printf("Error deserializing tolerations: %s", err)
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("kubernetes_tolerations[%d] is invalid: %s", index, err))
+			continue
 		}
 		tolerations = append(tolerations, toleration)
 	}
-	return tolerations
+	if errs != nil {
+		return nil, errs
+	}
+	return tolerations, nil
+}
+
+// marshalToleration restores the number of 'tolerationSeconds', HCL converts the values of a string map to strings
+func marshalToleration(rawToleration map[string]string) ([]byte, error) {
+	toleration := make(map[string]any, len(rawToleration))
+	for key, value := range rawToleration {
+		toleration[key] = value
+	}
+	if value, ok := rawToleration["tolerationSeconds"]; ok {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("tolerationSeconds %q is not a number of seconds", value)
+		}
+		toleration["tolerationSeconds"] = seconds
+	}
+	return json.Marshal(toleration)
+}
+
+func validateToleration(toleration v1.Toleration) error {
+	switch toleration.Operator {
+	case "", v1.TolerationOpEqual:
+		if toleration.Key == "" {
+			return fmt.Errorf("operator '%s' requires a key", v1.TolerationOpEqual)
+		}
+	case v1.TolerationOpExists:
+		if toleration.Value != "" {
+			return fmt.Errorf("value must be empty when operator is '%s'", v1.TolerationOpExists)
+		}
+	default:
+		return fmt.Errorf("unsupported operator '%s', allowed values: '%s', '%s'", toleration.Operator, v1.TolerationOpEqual, v1.TolerationOpExists)
+	}
+
+	switch toleration.Effect {
+	case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return fmt.Errorf("unsupported effect '%s', allowed values: '%s', '%s', '%s'", toleration.Effect, v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute)
+	}
+	if toleration.TolerationSeconds != nil && toleration.Effect != v1.TaintEffectNoExecute {
+		return fmt.Errorf("tolerationSeconds requires effect '%s'", v1.TaintEffectNoExecute)
+	}
+
+	return nil
 }
 
 func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
@@ -145,71 +495,220 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	appContext.Put(buildercommon.PackerHook, hook)
 	appContext.Put(buildercommon.PackerUi, ui)
 
+	buildId := buildercommon.GenerateBuildId()
+	appContext.Put(buildercommon.BuildId, buildId)
+	appContext.Put(buildercommon.ImageName, b.config.KubernetesName)
+	ui.Say(fmt.Sprintf("resources of this build are labeled with %s=%s", buildercommon.BuildIdLabel, buildId))
+
 	osFamily := vm.GetOSFamily(b.config.KubevirtOsPreference)
 	appContext.Put(buildercommon.VirtualMachineOsFamily, &osFamily)
 
-	steps := []multistep.Step{
-		&stepDef.StepDeployVM{
+	// '-on-error=abort' skips every cleanup, '-debug' is mostly used to investigate failed provisioning
+	keepOnError := b.config.KeepVirtualMachineOnError || b.config.PackerOnError == "abort" || b.config.PackerDebug
+
+	vmOptions := generator.VirtualMachineOptions{
+		Name:           b.config.KubernetesName,
+		NodeSelectors:  b.config.KubernetesNodeSelectors,
+		Tolerations:    b.tolerations,
+		OsDistribution: b.config.KubevirtOsPreference,
+		OsFamily:       osFamily,
+		DiskSpace:      b.config.VirtualMachineDiskSpace,
+		ImageSource: generator.ImageSource{
+			URL:                b.config.SourceUrl,
+			AWSAccessKeyId:     b.config.SourceAWSAccessKeyId,
+			AWSSecretAccessKey: b.config.SourceAWSSecretAccessKey,
+		},
+		UserProvisioning: generator.UserProvisioning{
+			CloudInit: b.config.VirtualMachineLinuxCloudInit,
+			Sysprep:   b.config.VirtualMachineWindowsSysprep,
+		},
+		Readiness: generator.ReadinessOptions{
+			Strategy:     buildercommon.ReadyStrategy(b.config.ReadyStrategy),
+			Command:      b.config.ReadyCommand,
+			InitialDelay: b.config.ReadyInitialDelay,
+			Period:       b.config.ReadyPeriod,
+			Timeout:      b.config.ReadyTimeout,
+		},
+		Labels:      buildercommon.BuildLabels(buildId),
+		Annotations: buildercommon.BuildAnnotations(b.config.PackerBuildName),
+	}
+	switch strings.ToLower(b.config.Comm.Type) {
+	case "ssh":
+		vmOptions.Readiness.Port = buildercommon.DefaultSSHPort
+	case "winrm":
+		vmOptions.Readiness.Port = buildercommon.DefaultWinRMPort
+	}
+	withoutCommunicator := strings.ToLower(b.config.Comm.Type) == "none"
+	if withoutCommunicator {
+		// The guest powering itself off must not be restarted, the readiness probe becomes the completion signal
+		runStrategy := kubevirtv1.RunStrategyRerunOnFailure
+		vmOptions.RunStrategy = &runStrategy
+		if buildercommon.CompletionSignal(b.config.CompletionSignal) == buildercommon.CompletionSignalGuestAgent {
+			vmOptions.Readiness.Strategy = buildercommon.ReadyStrategyExec
+			vmOptions.Readiness.Command = b.config.CompletionCommand
+		}
+	}
+
+	namespace := b.config.KubernetesNamespace
+	var destroyArtifact func() error
+	var steps []multistep.Step
+	steps = append(steps, &stepDef.StepCleanupOrphans{
+		VirtClient:        b.virtClient,
+		Namespaces:        b.config.OrphanCleanupNamespaces,
+		TTL:               b.config.OrphanCleanupTTL,
+		ExpiredNamespaces: b.config.KubernetesNamespaceEphemeral,
+	})
+	if b.config.KubernetesNamespaceEphemeral {
+		namespace = generator.BuildUniqueName(b.config.KubernetesNamespace, buildId)
+		if !b.config.KubernetesNamespaceTeardown {
+			destroyArtifact = func() error {
+				return stepDef.DeleteEphemeralNamespace(b.virtClient, namespace)
+			}
+		}
+		steps = append(steps, &stepDef.StepEphemeralNamespace{
 			VirtClient: b.virtClient,
-			KubeClient: b.kubeClient,
-			VmOptions: generator.VirtualMachineOptions{
-				Name:           b.config.KubernetesName,
-				Namespace:      b.config.KubernetesNamespace,
-				NodeSelectors:  b.config.KubernetesNodeSelectors,
-				Tolerations:    decodeTolerations(b.config.KubernetesTolerations),
-				OsDistribution: b.config.KubevirtOsPreference,
-				OsFamily:       osFamily,
-				DiskSpace:      b.config.VirtualMachineDiskSpace,
-				ImageSource: generator.ImageSource{
-					URL:                b.config.SourceUrl,
-					AWSAccessKeyId:     b.config.SourceAWSAccessKeyId,
-					AWSSecretAccessKey: b.config.SourceAWSSecretAccessKey,
-				},
-				UserProvisioning: generator.UserProvisioning{
-					CloudInit: b.config.VirtualMachineLinuxCloudInit,
-					Sysprep:   b.config.VirtualMachineWindowsSysprep,
-				},
+			NamespaceOptions: generator.NamespaceOptions{
+				Name:        namespace,
+				Quota:       b.config.KubernetesNamespaceQuota,
+				TTL:         b.config.KubernetesNamespaceTTL,
+				Labels:      buildercommon.BuildLabels(buildId),
+				Annotations: buildercommon.BuildAnnotations(b.config.PackerBuildName),
 			},
+			KeepOnError:      keepOnError,
+			DeleteAfterBuild: b.config.KubernetesNamespaceTeardown,
+		})
+	}
+
+	vmOptions.Namespace = namespace
+	steps = append(steps,
+		&stepDef.StepStreamEvents{
+			VirtClient: b.virtClient,
+			Namespace:  namespace,
+			Name:       b.config.KubernetesName,
+		},
+		&stepDef.StepDeployVM{
+			VirtClient:          b.virtClient,
+			KubeClient:          b.kubeClient,
+			VmOptions:           vmOptions,
 			VmDeploymentTimeOut: b.config.VirtualMachineDeploymentTimeOut,
+			ConflictPolicy:      buildercommon.ConflictPolicy(b.config.ConflictPolicy),
+			UniqueName:          b.config.KubernetesNameUnique,
+			KeepOnError:         keepOnError,
+			SkipReadyWait:       withoutCommunicator,
+			DiagnosticsDir:      b.config.DiagnosticsDir,
 		},
-		&stepDef.StepPortForwardVM{
+	)
+	if withoutCommunicator {
+		steps = append(steps, &stepDef.StepWaitForCompletion{
 			VirtClient: b.virtClient,
-			Comm:       b.config.Comm,
-		},
-		&communicator.StepConnect{
-			Config: &b.config.Comm,
-			Host: func(bag multistep.StateBag) (string, error) {
-				return buildercommon.VirtualMachineHost, nil
-			},
-			SSHConfig: func(bag multistep.StateBag) (*gossh.ClientConfig, error) {
-				return &gossh.ClientConfig{
-					User: buildercommon.VirtualMachineUsername,
-					Auth: []gossh.AuthMethod{
-						gossh.Password(buildercommon.VirtualMachinePassword),
-					},
-					HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-				}, nil
-			},
-			SSHPort: func(bag multistep.StateBag) (int, error) {
-				return buildercommon.GetOrDefault(b.config.Comm.SSHPort, buildercommon.DefaultSSHPort), nil
-			},
-			WinRMConfig: func(bag multistep.StateBag) (*communicator.WinRMConfig, error) {
-				return &communicator.WinRMConfig{
-					Username: buildercommon.VirtualMachineUsername,
-					Password: buildercommon.VirtualMachinePassword,
-				}, nil
-			},
-			WinRMPort: func(bag multistep.StateBag) (int, error) {
-				return buildercommon.GetOrDefault(b.config.Comm.WinRMPort, buildercommon.DefaultWinRMPort), nil
+			Signal:     buildercommon.CompletionSignal(b.config.CompletionSignal),
+			Timeout:    b.config.CompletionTimeOut,
+		})
+	} else {
+		if buildercommon.ConnectivityMode(b.config.ConnectivityMode) == buildercommon.ConnectivityModePortForward {
+			steps = append(steps, &stepDef.StepPortForwardVM{
+				VirtClient: b.virtClient,
+				Comm:       b.config.Comm,
+				Transport:  buildercommon.PortForwardTransport(b.config.PortForwardTransport),
+				ExtraPorts: b.config.ExtraPortForwards,
+			})
+		} else {
+			steps = append(steps, &stepDef.StepDirectConnectivity{
+				VirtClient:     b.virtClient,
+				Mode:           buildercommon.ConnectivityMode(b.config.ConnectivityMode),
+				Comm:           b.config.Comm,
+				ConflictPolicy: buildercommon.ConflictPolicy(b.config.ConflictPolicy),
+			})
+		}
+		steps = append(steps,
+			&communicator.StepConnect{
+				Config: &b.config.Comm,
+				Host: func(bag multistep.StateBag) (string, error) {
+					return (&buildercommon.AppContext{State: bag}).GetCommunicatorHost(), nil
+				},
+				SSHConfig: func(bag multistep.StateBag) (*gossh.ClientConfig, error) {
+					return &gossh.ClientConfig{
+						User: buildercommon.VirtualMachineUsername,
+						Auth: []gossh.AuthMethod{
+							gossh.Password(buildercommon.VirtualMachinePassword),
+						},
+						HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+					}, nil
+				},
+				SSHPort: func(bag multistep.StateBag) (int, error) {
+					port := (&buildercommon.AppContext{State: bag}).GetCommunicatorPort()
+					return buildercommon.GetOrDefault(port, buildercommon.DefaultSSHPort), nil
+				},
+				WinRMConfig: func(bag multistep.StateBag) (*communicator.WinRMConfig, error) {
+					return &communicator.WinRMConfig{
+						Username: buildercommon.VirtualMachineUsername,
+						Password: buildercommon.VirtualMachinePassword,
+					}, nil
+				},
+				WinRMPort: func(bag multistep.StateBag) (int, error) {
+					port := (&buildercommon.AppContext{State: bag}).GetCommunicatorPort()
+					return buildercommon.GetOrDefault(port, buildercommon.DefaultWinRMPort), nil
+				},
 			},
+		)
+	}
+
+	var shutdownStep multistep.Step = &stepDef.StepShutdownVM{
+		VirtClient:      b.virtClient,
+		ShutdownCommand: b.config.ShutdownCommand,
+		ShutdownTimeout: b.config.ShutdownTimeout,
+	}
+	if osFamily == vm.Windows && *b.config.Generalize.Enabled {
+		unattend := b.config.Generalize.WindowsUnattend
+		if unattend == "" {
+			var err error
+			unattend, err = generator.GenerateGeneralizeUnattend(buildercommon.VirtualMachineUsername)
+			if err != nil {
+				return nil, err
+			}
+		}
+		shutdownStep = &stepDef.StepGeneralizeWindows{
+			VirtClient:      b.virtClient,
+			Unattend:        unattend,
+			Timeout:         b.config.Generalize.Timeout,
+			ShutdownTimeout: b.config.ShutdownTimeout,
+			Options:         b.config.Generalize.options(),
+			ConflictPolicy:  buildercommon.ConflictPolicy(b.config.ConflictPolicy),
+		}
+	}
+
+	steps = append(steps, &commonsteps.StepProvision{})
+	if osFamily == vm.Windows && b.config.Sparsify && !withoutCommunicator {
+		// The free space is zeroed before sysprep, the guest is shut down by it
+		steps = append(steps, &stepDef.StepZeroFreeSpace{
+			Timeout: b.config.SparsifyTimeOut,
+		})
+	}
+	steps = append(steps,
+		shutdownStep,
+		&stepDef.StepPrepareDisk{
+			VirtClient:        b.virtClient,
+			ConflictPolicy:    buildercommon.ConflictPolicy(b.config.ConflictPolicy),
+			Generalize:        *b.config.Generalize.Enabled,
+			GeneralizeOptions: b.config.Generalize.options(),
+			GeneralizeTimeOut: b.config.Generalize.Timeout,
+			Sparsify:          b.config.Sparsify,
+			SparsifyTimeOut:   b.config.SparsifyTimeOut,
+		},
+		&stepDef.StepConvertVM{
+			VirtClient:     b.virtClient,
+			KubeClient:     b.kubeClient,
+			ConflictPolicy: buildercommon.ConflictPolicy(b.config.ConflictPolicy),
+			OutputFormat:   generator.OutputFormat(b.config.OutputFormat),
+			Options:        b.config.Generalize.options(),
+			Timeout:        b.config.ConversionTimeOut,
 		},
-		&commonsteps.StepProvision{},
 		&stepDef.StepExportVM{
 			VirtClient:      b.virtClient,
 			VmExportTimeOut: b.config.VirtualMachineExportTimeOut,
+			ConflictPolicy:  buildercommon.ConflictPolicy(b.config.ConflictPolicy),
 		},
-		&stepDef.StepConvertVM{},
-	}
+	)
 
 	// Run!
 	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
@@ -218,8 +717,20 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	// If there was an error, return that
 	err := appContext.GetPackerError()
 	if err != nil {
+		_, aborted := state.GetOk("aborted")
+		if vm := appContext.GetVirtualMachine(); vm != nil && (keepOnError || aborted) {
+			var localPort int
+			if buildercommon.ConnectivityMode(b.config.ConnectivityMode) == buildercommon.ConnectivityModePortForward {
+				localPort = appContext.GetCommunicatorPort()
+			}
+			stepDef.PrintAccessInstructions(b.virtClient, ui, vm, b.config.Comm, localPort)
+		}
 		return nil, err
 	}
+	// Interrupted steps do not always record an error, the cleanups have already run at this point
+	if _, cancelled := state.GetOk(multistep.StateCancelled); cancelled {
+		return nil, fmt.Errorf("build was cancelled")
+	}
 
-	return appContext.BuildArtifact(builderId), nil
+	return appContext.BuildArtifact(builderId, destroyArtifact), nil
 }
@@ -10,76 +10,104 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName                 *string             `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType               *string             `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion               *string             `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug                     *bool               `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce                     *bool               `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError                   *string             `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars                  map[string]string   `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars             []string            `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	Type                            *string             `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect              *string             `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                         *string             `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                         *int                `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername                     *string             `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword                     *string             `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName                  *string             `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName         *string             `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHTemporaryKeyPairType         *string             `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
-	SSHTemporaryKeyPairBits         *int                `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
-	SSHCiphers                      []string            `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys          *bool               `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos                     []string            `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile               *string             `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile              *string             `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                          *bool               `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                      *string             `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout                  *string             `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth                    *bool               `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding       *bool               `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts            *int                `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost                  *string             `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort                  *int                `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth             *bool               `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername              *string             `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword              *string             `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive           *bool               `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile        *string             `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile       *string             `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod           *string             `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost                    *string             `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort                    *int                `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername                *string             `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword                *string             `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval            *string             `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout             *string             `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels                []string            `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels                 []string            `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey                    []byte              `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey                   []byte              `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                       *string             `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword                   *string             `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                       *string             `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy                    *bool               `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                       *int                `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout                    *string             `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL                     *bool               `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure                   *bool               `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM                    *bool               `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
-	KubernetesName                  *string             `mapstructure:"kubernetes_name" cty:"kubernetes_name" hcl:"kubernetes_name"`
-	KubernetesNamespace             *string             `mapstructure:"kubernetes_namespace" cty:"kubernetes_namespace" hcl:"kubernetes_namespace"`
-	KubernetesNodeSelectors         map[string]string   `mapstructure:"kubernetes_node_selectors" cty:"kubernetes_node_selectors" hcl:"kubernetes_node_selectors"`
-	KubernetesTolerations           []map[string]string `mapstructure:"kubernetes_tolerations" cty:"kubernetes_tolerations" hcl:"kubernetes_tolerations"`
-	KubevirtOsPreference            *string             `mapstructure:"kubevirt_os_preference" cty:"kubevirt_os_preference" hcl:"kubevirt_os_preference"`
-	SourceUrl                       *string             `mapstructure:"source_url" cty:"source_url" hcl:"source_url"`
-	SourceAWSAccessKeyId            *string             `mapstructure:"source_aws_access_key_id" required:"false" cty:"source_aws_access_key_id" hcl:"source_aws_access_key_id"`
-	SourceAWSSecretAccessKey        *string             `mapstructure:"source_aws_secret_access_key" required:"false" cty:"source_aws_secret_access_key" hcl:"source_aws_secret_access_key"`
-	VirtualMachineDiskSpace         *string             `mapstructure:"vm_disk_space" cty:"vm_disk_space" hcl:"vm_disk_space"`
-	VirtualMachineDeploymentTimeOut *string             `mapstructure:"vm_deployment_timeout" required:"false" cty:"vm_deployment_timeout" hcl:"vm_deployment_timeout"`
-	VirtualMachineExportTimeOut     *string             `mapstructure:"vm_export_timeout" required:"false" cty:"vm_export_timeout" hcl:"vm_export_timeout"`
-	VirtualMachineLinuxCloudInit    *string             `mapstructure:"vm_linux_cloud_init" required:"false" cty:"vm_linux_cloud_init" hcl:"vm_linux_cloud_init"`
-	VirtualMachineWindowsSysprep    *string             `mapstructure:"vm_windows_sysprep" required:"false" cty:"vm_windows_sysprep" hcl:"vm_windows_sysprep"`
+	PackerBuildName                 *string               `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType               *string               `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion               *string               `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug                     *bool                 `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce                     *bool                 `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError                   *string               `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars                  map[string]string     `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars             []string              `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	Type                            *string               `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect              *string               `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                         *string               `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                         *int                  `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername                     *string               `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword                     *string               `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName                  *string               `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName         *string               `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType         *string               `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits         *int                  `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                      []string              `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys          *bool                 `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos                     []string              `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile               *string               `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile              *string               `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                          *bool                 `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                      *string               `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout                  *string               `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth                    *bool                 `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding       *bool                 `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts            *int                  `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost                  *string               `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort                  *int                  `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth             *bool                 `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername              *string               `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword              *string               `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive           *bool                 `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile        *string               `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile       *string               `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod           *string               `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost                    *string               `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort                    *int                  `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername                *string               `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword                *string               `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval            *string               `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout             *string               `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels                []string              `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels                 []string              `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey                    []byte                `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey                   []byte                `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                       *string               `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword                   *string               `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                       *string               `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy                    *bool                 `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                       *int                  `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout                    *string               `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL                     *bool                 `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure                   *bool                 `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM                    *bool                 `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	ShutdownCommand                 *string               `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout                 *string               `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	KubernetesName                  *string               `mapstructure:"kubernetes_name" cty:"kubernetes_name" hcl:"kubernetes_name"`
+	KubernetesNameUnique            *bool                 `mapstructure:"kubernetes_name_unique" required:"false" cty:"kubernetes_name_unique" hcl:"kubernetes_name_unique"`
+	KubernetesNamespace             *string               `mapstructure:"kubernetes_namespace" cty:"kubernetes_namespace" hcl:"kubernetes_namespace"`
+	KubernetesNamespaceEphemeral    *bool                 `mapstructure:"kubernetes_namespace_ephemeral" required:"false" cty:"kubernetes_namespace_ephemeral" hcl:"kubernetes_namespace_ephemeral"`
+	KubernetesNamespaceQuota        map[string]string     `mapstructure:"kubernetes_namespace_quota" required:"false" cty:"kubernetes_namespace_quota" hcl:"kubernetes_namespace_quota"`
+	KubernetesNamespaceTTL          *string               `mapstructure:"kubernetes_namespace_ttl" required:"false" cty:"kubernetes_namespace_ttl" hcl:"kubernetes_namespace_ttl"`
+	KubernetesNamespaceTeardown     *bool                 `mapstructure:"kubernetes_namespace_teardown" required:"false" cty:"kubernetes_namespace_teardown" hcl:"kubernetes_namespace_teardown"`
+	KubernetesNodeSelectors         map[string]string     `mapstructure:"kubernetes_node_selectors" cty:"kubernetes_node_selectors" hcl:"kubernetes_node_selectors"`
+	KubernetesTolerations           []map[string]string   `mapstructure:"kubernetes_tolerations" cty:"kubernetes_tolerations" hcl:"kubernetes_tolerations"`
+	KubevirtOsPreference            *string               `mapstructure:"kubevirt_os_preference" cty:"kubevirt_os_preference" hcl:"kubevirt_os_preference"`
+	SourceUrl                       *string               `mapstructure:"source_url" cty:"source_url" hcl:"source_url"`
+	SourceAWSAccessKeyId            *string               `mapstructure:"source_aws_access_key_id" required:"false" cty:"source_aws_access_key_id" hcl:"source_aws_access_key_id"`
+	SourceAWSSecretAccessKey        *string               `mapstructure:"source_aws_secret_access_key" required:"false" cty:"source_aws_secret_access_key" hcl:"source_aws_secret_access_key"`
+	VirtualMachineDiskSpace         *string               `mapstructure:"vm_disk_space" cty:"vm_disk_space" hcl:"vm_disk_space"`
+	VirtualMachineDeploymentTimeOut *string               `mapstructure:"vm_deployment_timeout" required:"false" cty:"vm_deployment_timeout" hcl:"vm_deployment_timeout"`
+	VirtualMachineExportTimeOut     *string               `mapstructure:"vm_export_timeout" required:"false" cty:"vm_export_timeout" hcl:"vm_export_timeout"`
+	VirtualMachineLinuxCloudInit    *string               `mapstructure:"vm_linux_cloud_init" required:"false" cty:"vm_linux_cloud_init" hcl:"vm_linux_cloud_init"`
+	VirtualMachineWindowsSysprep    *string               `mapstructure:"vm_windows_sysprep" required:"false" cty:"vm_windows_sysprep" hcl:"vm_windows_sysprep"`
+	OrphanCleanupTTL                *string               `mapstructure:"orphan_cleanup_ttl" required:"false" cty:"orphan_cleanup_ttl" hcl:"orphan_cleanup_ttl"`
+	OrphanCleanupNamespaces         []string              `mapstructure:"orphan_cleanup_namespaces" required:"false" cty:"orphan_cleanup_namespaces" hcl:"orphan_cleanup_namespaces"`
+	ConflictPolicy                  *string               `mapstructure:"conflict_policy" required:"false" cty:"conflict_policy" hcl:"conflict_policy"`
+	KeepVirtualMachineOnError       *bool                 `mapstructure:"keep_vm_on_error" required:"false" cty:"keep_vm_on_error" hcl:"keep_vm_on_error"`
+	CompletionSignal                *string               `mapstructure:"vm_completion_signal" required:"false" cty:"vm_completion_signal" hcl:"vm_completion_signal"`
+	CompletionCommand               []string              `mapstructure:"vm_completion_command" required:"false" cty:"vm_completion_command" hcl:"vm_completion_command"`
+	CompletionTimeOut               *string               `mapstructure:"vm_completion_timeout" required:"false" cty:"vm_completion_timeout" hcl:"vm_completion_timeout"`
+	PortForwardTransport            *string               `mapstructure:"port_forward_transport" required:"false" cty:"port_forward_transport" hcl:"port_forward_transport"`
+	ConnectivityMode                *string               `mapstructure:"connectivity_mode" required:"false" cty:"connectivity_mode" hcl:"connectivity_mode"`
+	ExtraPortForwards               []string              `mapstructure:"extra_port_forwards" required:"false" cty:"extra_port_forwards" hcl:"extra_port_forwards"`
+	ReadyStrategy                   *string               `mapstructure:"vm_ready_strategy" required:"false" cty:"vm_ready_strategy" hcl:"vm_ready_strategy"`
+	ReadyCommand                    []string              `mapstructure:"vm_ready_command" required:"false" cty:"vm_ready_command" hcl:"vm_ready_command"`
+	ReadyInitialDelay               *string               `mapstructure:"vm_ready_initial_delay" required:"false" cty:"vm_ready_initial_delay" hcl:"vm_ready_initial_delay"`
+	ReadyPeriod                     *string               `mapstructure:"vm_ready_period" required:"false" cty:"vm_ready_period" hcl:"vm_ready_period"`
+	ReadyTimeout                    *string               `mapstructure:"vm_ready_timeout" required:"false" cty:"vm_ready_timeout" hcl:"vm_ready_timeout"`
+	DiagnosticsDir                  *string               `mapstructure:"diagnostics_dir" required:"false" cty:"diagnostics_dir" hcl:"diagnostics_dir"`
+	Generalize                      *FlatGeneralizeConfig `mapstructure:"generalize" required:"false" cty:"generalize" hcl:"generalize"`
+	Sparsify                        *bool                 `mapstructure:"sparsify" required:"false" cty:"sparsify" hcl:"sparsify"`
+	SparsifyTimeOut                 *string               `mapstructure:"sparsify_timeout" required:"false" cty:"sparsify_timeout" hcl:"sparsify_timeout"`
+	OutputFormat                    *string               `mapstructure:"output_format" required:"false" cty:"output_format" hcl:"output_format"`
+	ConversionTimeOut               *string               `mapstructure:"conversion_timeout" required:"false" cty:"conversion_timeout" hcl:"conversion_timeout"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -94,76 +122,151 @@ func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec }
 // The decoded values from this spec will then be applied to a FlatConfig.
 func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"packer_build_name":            &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
-		"packer_builder_type":          &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
-		"packer_core_version":          &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
-		"packer_debug":                 &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
-		"packer_force":                 &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
-		"packer_on_error":              &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
-		"packer_user_variables":        &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
-		"packer_sensitive_variables":   &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
-		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
-		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
-		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
-		"ssh_port":                     &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
-		"ssh_username":                 &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
-		"ssh_password":                 &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
-		"ssh_keypair_name":             &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
-		"temporary_key_pair_name":      &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
-		"temporary_key_pair_type":      &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
-		"temporary_key_pair_bits":      &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
-		"ssh_ciphers":                  &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
-		"ssh_clear_authorized_keys":    &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
-		"ssh_key_exchange_algorithms":  &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
-		"ssh_private_key_file":         &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
-		"ssh_certificate_file":         &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
-		"ssh_pty":                      &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
-		"ssh_timeout":                  &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
-		"ssh_wait_timeout":             &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
-		"ssh_agent_auth":               &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_disable_agent_forwarding": &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
-		"ssh_handshake_attempts":       &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
-		"ssh_bastion_host":             &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
-		"ssh_bastion_port":             &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
-		"ssh_bastion_agent_auth":       &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_bastion_username":         &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
-		"ssh_bastion_password":         &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
-		"ssh_bastion_interactive":      &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
-		"ssh_bastion_private_key_file": &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
-		"ssh_bastion_certificate_file": &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
-		"ssh_file_transfer_method":     &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
-		"ssh_proxy_host":               &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
-		"ssh_proxy_port":               &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
-		"ssh_proxy_username":           &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
-		"ssh_proxy_password":           &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
-		"ssh_keep_alive_interval":      &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
-		"ssh_read_write_timeout":       &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
-		"ssh_remote_tunnels":           &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_local_tunnels":            &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_public_key":               &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
-		"ssh_private_key":              &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
-		"winrm_username":               &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
-		"winrm_password":               &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
-		"winrm_host":                   &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
-		"winrm_no_proxy":               &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
-		"winrm_port":                   &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
-		"winrm_timeout":                &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
-		"winrm_use_ssl":                &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
-		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
-		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
-		"kubernetes_name":              &hcldec.AttrSpec{Name: "kubernetes_name", Type: cty.String, Required: false},
-		"kubernetes_namespace":         &hcldec.AttrSpec{Name: "kubernetes_namespace", Type: cty.String, Required: false},
-		"kubernetes_node_selectors":    &hcldec.AttrSpec{Name: "kubernetes_node_selectors", Type: cty.Map(cty.String), Required: false},
-		"kubernetes_tolerations":       &hcldec.AttrSpec{Name: "kubernetes_tolerations", Type: cty.List(cty.Map(cty.String)), Required: false},
-		"kubevirt_os_preference":       &hcldec.AttrSpec{Name: "kubevirt_os_preference", Type: cty.String, Required: false},
-		"source_url":                   &hcldec.AttrSpec{Name: "source_url", Type: cty.String, Required: false},
-		"source_aws_access_key_id":     &hcldec.AttrSpec{Name: "source_aws_access_key_id", Type: cty.String, Required: false},
-		"source_aws_secret_access_key": &hcldec.AttrSpec{Name: "source_aws_secret_access_key", Type: cty.String, Required: false},
-		"vm_disk_space":                &hcldec.AttrSpec{Name: "vm_disk_space", Type: cty.String, Required: false},
-		"vm_deployment_timeout":        &hcldec.AttrSpec{Name: "vm_deployment_timeout", Type: cty.String, Required: false},
-		"vm_export_timeout":            &hcldec.AttrSpec{Name: "vm_export_timeout", Type: cty.String, Required: false},
-		"vm_linux_cloud_init":          &hcldec.AttrSpec{Name: "vm_linux_cloud_init", Type: cty.String, Required: false},
-		"vm_windows_sysprep":           &hcldec.AttrSpec{Name: "vm_windows_sysprep", Type: cty.String, Required: false},
+		"packer_build_name":              &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":            &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_core_version":            &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
+		"packer_debug":                   &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":                   &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":                &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":          &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables":     &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"communicator":                   &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
+		"pause_before_connecting":        &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
+		"ssh_host":                       &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
+		"ssh_port":                       &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
+		"ssh_username":                   &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
+		"ssh_password":                   &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
+		"ssh_keypair_name":               &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_name":        &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_type":        &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
+		"temporary_key_pair_bits":        &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
+		"ssh_ciphers":                    &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
+		"ssh_clear_authorized_keys":      &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
+		"ssh_key_exchange_algorithms":    &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
+		"ssh_private_key_file":           &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
+		"ssh_certificate_file":           &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
+		"ssh_pty":                        &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
+		"ssh_timeout":                    &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
+		"ssh_wait_timeout":               &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
+		"ssh_agent_auth":                 &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_disable_agent_forwarding":   &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
+		"ssh_handshake_attempts":         &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
+		"ssh_bastion_host":               &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
+		"ssh_bastion_port":               &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
+		"ssh_bastion_agent_auth":         &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_bastion_username":           &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
+		"ssh_bastion_password":           &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
+		"ssh_bastion_interactive":        &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
+		"ssh_bastion_private_key_file":   &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
+		"ssh_bastion_certificate_file":   &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
+		"ssh_file_transfer_method":       &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
+		"ssh_proxy_host":                 &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
+		"ssh_proxy_port":                 &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
+		"ssh_proxy_username":             &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
+		"ssh_proxy_password":             &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
+		"ssh_keep_alive_interval":        &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
+		"ssh_read_write_timeout":         &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
+		"ssh_remote_tunnels":             &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_local_tunnels":              &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_public_key":                 &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
+		"ssh_private_key":                &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
+		"winrm_username":                 &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
+		"winrm_password":                 &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
+		"winrm_host":                     &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
+		"winrm_no_proxy":                 &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
+		"winrm_port":                     &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
+		"winrm_timeout":                  &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
+		"winrm_use_ssl":                  &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
+		"winrm_insecure":                 &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
+		"winrm_use_ntlm":                 &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		"shutdown_command":               &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
+		"shutdown_timeout":               &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
+		"kubernetes_name":                &hcldec.AttrSpec{Name: "kubernetes_name", Type: cty.String, Required: false},
+		"kubernetes_name_unique":         &hcldec.AttrSpec{Name: "kubernetes_name_unique", Type: cty.Bool, Required: false},
+		"kubernetes_namespace":           &hcldec.AttrSpec{Name: "kubernetes_namespace", Type: cty.String, Required: false},
+		"kubernetes_namespace_ephemeral": &hcldec.AttrSpec{Name: "kubernetes_namespace_ephemeral", Type: cty.Bool, Required: false},
+		"kubernetes_namespace_quota":     &hcldec.AttrSpec{Name: "kubernetes_namespace_quota", Type: cty.Map(cty.String), Required: false},
+		"kubernetes_namespace_ttl":       &hcldec.AttrSpec{Name: "kubernetes_namespace_ttl", Type: cty.String, Required: false},
+		"kubernetes_namespace_teardown":  &hcldec.AttrSpec{Name: "kubernetes_namespace_teardown", Type: cty.Bool, Required: false},
+		"kubernetes_node_selectors":      &hcldec.AttrSpec{Name: "kubernetes_node_selectors", Type: cty.Map(cty.String), Required: false},
+		"kubernetes_tolerations":         &hcldec.AttrSpec{Name: "kubernetes_tolerations", Type: cty.List(cty.Map(cty.String)), Required: false},
+		"kubevirt_os_preference":         &hcldec.AttrSpec{Name: "kubevirt_os_preference", Type: cty.String, Required: false},
+		"source_url":                     &hcldec.AttrSpec{Name: "source_url", Type: cty.String, Required: false},
+		"source_aws_access_key_id":       &hcldec.AttrSpec{Name: "source_aws_access_key_id", Type: cty.String, Required: false},
+		"source_aws_secret_access_key":   &hcldec.AttrSpec{Name: "source_aws_secret_access_key", Type: cty.String, Required: false},
+		"vm_disk_space":                  &hcldec.AttrSpec{Name: "vm_disk_space", Type: cty.String, Required: false},
+		"vm_deployment_timeout":          &hcldec.AttrSpec{Name: "vm_deployment_timeout", Type: cty.String, Required: false},
+		"vm_export_timeout":              &hcldec.AttrSpec{Name: "vm_export_timeout", Type: cty.String, Required: false},
+		"vm_linux_cloud_init":            &hcldec.AttrSpec{Name: "vm_linux_cloud_init", Type: cty.String, Required: false},
+		"vm_windows_sysprep":             &hcldec.AttrSpec{Name: "vm_windows_sysprep", Type: cty.String, Required: false},
+		"orphan_cleanup_ttl":             &hcldec.AttrSpec{Name: "orphan_cleanup_ttl", Type: cty.String, Required: false},
+		"orphan_cleanup_namespaces":      &hcldec.AttrSpec{Name: "orphan_cleanup_namespaces", Type: cty.List(cty.String), Required: false},
+		"conflict_policy":                &hcldec.AttrSpec{Name: "conflict_policy", Type: cty.String, Required: false},
+		"keep_vm_on_error":               &hcldec.AttrSpec{Name: "keep_vm_on_error", Type: cty.Bool, Required: false},
+		"vm_completion_signal":           &hcldec.AttrSpec{Name: "vm_completion_signal", Type: cty.String, Required: false},
+		"vm_completion_command":          &hcldec.AttrSpec{Name: "vm_completion_command", Type: cty.List(cty.String), Required: false},
+		"vm_completion_timeout":          &hcldec.AttrSpec{Name: "vm_completion_timeout", Type: cty.String, Required: false},
+		"port_forward_transport":         &hcldec.AttrSpec{Name: "port_forward_transport", Type: cty.String, Required: false},
+		"connectivity_mode":              &hcldec.AttrSpec{Name: "connectivity_mode", Type: cty.String, Required: false},
+		"extra_port_forwards":            &hcldec.AttrSpec{Name: "extra_port_forwards", Type: cty.List(cty.String), Required: false},
+		"vm_ready_strategy":              &hcldec.AttrSpec{Name: "vm_ready_strategy", Type: cty.String, Required: false},
+		"vm_ready_command":               &hcldec.AttrSpec{Name: "vm_ready_command", Type: cty.List(cty.String), Required: false},
+		"vm_ready_initial_delay":         &hcldec.AttrSpec{Name: "vm_ready_initial_delay", Type: cty.String, Required: false},
+		"vm_ready_period":                &hcldec.AttrSpec{Name: "vm_ready_period", Type: cty.String, Required: false},
+		"vm_ready_timeout":               &hcldec.AttrSpec{Name: "vm_ready_timeout", Type: cty.String, Required: false},
+		"diagnostics_dir":                &hcldec.AttrSpec{Name: "diagnostics_dir", Type: cty.String, Required: false},
+		"generalize":                     &hcldec.BlockSpec{TypeName: "generalize", Nested: hcldec.ObjectSpec((*FlatGeneralizeConfig)(nil).HCL2Spec())},
+		"sparsify":                       &hcldec.AttrSpec{Name: "sparsify", Type: cty.Bool, Required: false},
+		"sparsify_timeout":               &hcldec.AttrSpec{Name: "sparsify_timeout", Type: cty.String, Required: false},
+		"output_format":                  &hcldec.AttrSpec{Name: "output_format", Type: cty.String, Required: false},
+		"conversion_timeout":             &hcldec.AttrSpec{Name: "conversion_timeout", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatGeneralizeConfig is an auto-generated flat version of GeneralizeConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatGeneralizeConfig struct {
+	Enabled            *bool             `mapstructure:"enabled" required:"false" cty:"enabled" hcl:"enabled"`
+	Operations         []string          `mapstructure:"operations" required:"false" cty:"operations" hcl:"operations"`
+	DisabledOperations []string          `mapstructure:"disabled_operations" required:"false" cty:"disabled_operations" hcl:"disabled_operations"`
+	KeepUserAccounts   []string          `mapstructure:"keep_user_accounts" required:"false" cty:"keep_user_accounts" hcl:"keep_user_accounts"`
+	RemoveUserAccounts []string          `mapstructure:"remove_user_accounts" required:"false" cty:"remove_user_accounts" hcl:"remove_user_accounts"`
+	RunCommands        []string          `mapstructure:"run_commands" required:"false" cty:"run_commands" hcl:"run_commands"`
+	FirstbootCommands  []string          `mapstructure:"firstboot_commands" required:"false" cty:"firstboot_commands" hcl:"firstboot_commands"`
+	RootPassword       *string           `mapstructure:"root_password" required:"false" cty:"root_password" hcl:"root_password"`
+	Image              *string           `mapstructure:"image" required:"false" cty:"image" hcl:"image"`
+	Requests           map[string]string `mapstructure:"requests" required:"false" cty:"requests" hcl:"requests"`
+	Limits             map[string]string `mapstructure:"limits" required:"false" cty:"limits" hcl:"limits"`
+	Timeout            *string           `mapstructure:"timeout" required:"false" cty:"timeout" hcl:"timeout"`
+	WindowsUnattend    *string           `mapstructure:"windows_unattend" required:"false" cty:"windows_unattend" hcl:"windows_unattend"`
+}
+
+// FlatMapstructure returns a new FlatGeneralizeConfig.
+// FlatGeneralizeConfig is an auto-generated flat version of GeneralizeConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*GeneralizeConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatGeneralizeConfig)
+}
+
+// HCL2Spec returns the hcl spec of a GeneralizeConfig.
+// This spec is used by HCL to read the fields of GeneralizeConfig.
+// The decoded values from this spec will then be applied to a FlatGeneralizeConfig.
+func (*FlatGeneralizeConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"enabled":              &hcldec.AttrSpec{Name: "enabled", Type: cty.Bool, Required: false},
+		"operations":           &hcldec.AttrSpec{Name: "operations", Type: cty.List(cty.String), Required: false},
+		"disabled_operations":  &hcldec.AttrSpec{Name: "disabled_operations", Type: cty.List(cty.String), Required: false},
+		"keep_user_accounts":   &hcldec.AttrSpec{Name: "keep_user_accounts", Type: cty.List(cty.String), Required: false},
+		"remove_user_accounts": &hcldec.AttrSpec{Name: "remove_user_accounts", Type: cty.List(cty.String), Required: false},
+		"run_commands":         &hcldec.AttrSpec{Name: "run_commands", Type: cty.List(cty.String), Required: false},
+		"firstboot_commands":   &hcldec.AttrSpec{Name: "firstboot_commands", Type: cty.List(cty.String), Required: false},
+		"root_password":        &hcldec.AttrSpec{Name: "root_password", Type: cty.String, Required: false},
+		"image":                &hcldec.AttrSpec{Name: "image", Type: cty.String, Required: false},
+		"requests":             &hcldec.AttrSpec{Name: "requests", Type: cty.Map(cty.String), Required: false},
+		"limits":               &hcldec.AttrSpec{Name: "limits", Type: cty.Map(cty.String), Required: false},
+		"timeout":              &hcldec.AttrSpec{Name: "timeout", Type: cty.String, Required: false},
+		"windows_unattend":     &hcldec.AttrSpec{Name: "windows_unattend", Type: cty.String, Required: false},
 	}
 	return s
 }
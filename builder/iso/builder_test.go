@@ -0,0 +1,287 @@
+package iso
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+)
+
+func validConfig() Config {
+	config := Config{
+		Comm: communicator.Config{
+			Type:  "ssh",
+			SSH:   communicator.SSH{SSHPort: 2222},
+			WinRM: communicator.WinRM{WinRMTimeout: 30 * time.Second},
+		},
+		KubernetesName:          "ubuntu",
+		KubernetesNamespace:     "packer",
+		KubevirtOsPreference:    "ubuntu",
+		SourceUrl:               "https://cloud-images.ubuntu.com/jammy/current/jammy-server-cloudimg-amd64.img",
+		VirtualMachineDiskSpace: "10Gi",
+		ConflictPolicy:          "fail",
+		PortForwardTransport:    "vmi",
+		ConnectivityMode:        "port_forward",
+		ReadyStrategy:           "cloud-init",
+		// Timeouts are set to their defaults by Prepare
+		VirtualMachineDeploymentTimeOut: 10 * time.Minute,
+		VirtualMachineExportTimeOut:     5 * time.Minute,
+		CompletionTimeOut:               30 * time.Minute,
+		ReadyInitialDelay:               30 * time.Second,
+		ReadyPeriod:                     10 * time.Second,
+		ReadyTimeout:                    10 * time.Second,
+		SparsifyTimeOut:                 15 * time.Minute,
+		ConversionTimeOut:               15 * time.Minute,
+		Generalize:                      GeneralizeConfig{Timeout: 2 * time.Minute},
+	}
+	config.ShutdownTimeout = 5 * time.Minute
+	return config
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		mutate   func(c *Config)
+		expected []string
+	}{
+		"valid": {
+			mutate: func(c *Config) {},
+		},
+		"missing required fields": {
+			mutate: func(c *Config) {
+				c.KubernetesName = ""
+				c.KubevirtOsPreference = ""
+				c.SourceUrl = ""
+			},
+			expected: []string{"kubernetes_name must be specified", "kubevirt_os_preference must be specified", "source_url must be specified"},
+		},
+		"invalid disk space": {
+			mutate: func(c *Config) {
+				c.VirtualMachineDiskSpace = "10 gigs"
+			},
+			expected: []string{"vm_disk_space"},
+		},
+		"invalid names": {
+			mutate: func(c *Config) {
+				c.KubernetesName = "Ubuntu_22"
+				c.KubernetesNamespace = "packer.builds"
+			},
+			expected: []string{"kubernetes_name", "kubernetes_namespace"},
+		},
+		"derived names too long": {
+			mutate: func(c *Config) {
+				c.KubernetesName = strings.Repeat("a", 60)
+			},
+			expected: []string{"kubernetes_name is too long"},
+		},
+		"unsupported source scheme": {
+			mutate: func(c *Config) {
+				c.SourceUrl = "s3://bucket/image.img"
+			},
+			expected: []string{"unsupported scheme"},
+		},
+		"unsupported conflict policy": {
+			mutate: func(c *Config) {
+				c.ConflictPolicy = "overwrite"
+			},
+			expected: []string{"conflict_policy"},
+		},
+		"ephemeral namespace prefix too long": {
+			mutate: func(c *Config) {
+				c.KubernetesNamespaceEphemeral = true
+				c.KubernetesNamespace = strings.Repeat("a", 60)
+			},
+			expected: []string{"kubernetes_namespace"},
+		},
+		"namespace teardown without ephemeral namespace": {
+			mutate: func(c *Config) {
+				c.KubernetesNamespaceTeardown = true
+			},
+			expected: []string{"kubernetes_namespace_teardown requires kubernetes_namespace_ephemeral"},
+		},
+		"invalid namespace quota": {
+			mutate: func(c *Config) {
+				c.KubernetesNamespaceQuota = map[string]string{"requests.cpu": "eight"}
+			},
+			expected: []string{"kubernetes_namespace_quota[requests.cpu]"},
+		},
+		"communicator none": {
+			mutate: func(c *Config) {
+				c.Comm.Type = "none"
+				c.CompletionSignal = "poweroff"
+			},
+		},
+		"communicator none with guest agent signal and no command": {
+			mutate: func(c *Config) {
+				c.Comm.Type = "none"
+				c.CompletionSignal = "guest_agent"
+				c.ShutdownCommand = "sudo shutdown -P now"
+			},
+			expected: []string{"vm_completion_command must be specified", "shutdown_command requires a communicator"},
+		},
+		"unsupported port forward transport": {
+			mutate: func(c *Config) {
+				c.PortForwardTransport = "service"
+			},
+			expected: []string{"port_forward_transport"},
+		},
+		"service connectivity with a name starting with a digit": {
+			mutate: func(c *Config) {
+				c.ConnectivityMode = "service"
+				c.KubernetesName = "2204-ubuntu"
+			},
+			expected: []string{"kubernetes_name"},
+		},
+		"unsupported connectivity mode": {
+			mutate: func(c *Config) {
+				c.ConnectivityMode = "node_port"
+			},
+			expected: []string{"connectivity_mode"},
+		},
+		"extra port forwards": {
+			mutate: func(c *Config) {
+				c.ExtraPortForwards = []string{"3389", "8080:80"}
+			},
+		},
+		"invalid extra port forwards": {
+			mutate: func(c *Config) {
+				c.ExtraPortForwards = []string{"rdp", "80:80"}
+			},
+			expected: []string{"extra_port_forwards[0] is invalid", "extra_port_forwards[1] uses a reserved local port"},
+		},
+		"exec ready strategy without command": {
+			mutate: func(c *Config) {
+				c.ReadyStrategy = "exec"
+			},
+			expected: []string{"vm_ready_command must be specified"},
+		},
+		"tcp ready strategy without communicator": {
+			mutate: func(c *Config) {
+				c.Comm.Type = "none"
+				c.CompletionSignal = "poweroff"
+				c.ReadyStrategy = "tcp"
+			},
+			expected: []string{"requires communicator 'ssh' or 'winrm'"},
+		},
+		"unsupported ready strategy": {
+			mutate: func(c *Config) {
+				c.ReadyStrategy = "ping"
+			},
+			expected: []string{"vm_ready_strategy"},
+		},
+		"negative timeout": {
+			mutate: func(c *Config) {
+				c.VirtualMachineExportTimeOut = -time.Minute
+			},
+			expected: []string{"vm_export_timeout"},
+		},
+		"zero timeout": {
+			mutate: func(c *Config) {
+				c.VirtualMachineDeploymentTimeOut = 0
+			},
+			expected: []string{"vm_deployment_timeout must be greater than zero"},
+		},
+		"invalid generalize settings": {
+			mutate: func(c *Config) {
+				c.Generalize.DisabledOperations = []string{"-ssh-hostkeys"}
+				c.Generalize.RootPassword = "file:/root/password"
+				c.Generalize.Limits = map[string]string{"memory": "1 gig"}
+			},
+			expected: []string{"generalize operation \"-ssh-hostkeys\"", "generalize.root_password", "generalize.limits[memory]"},
+		},
+		"windows generalization without communicator": {
+			mutate: func(c *Config) {
+				enabled := true
+				c.KubevirtOsPreference = "windows.2k22"
+				c.ReadyStrategy = "agent"
+				c.Comm.Type = "none"
+				c.CompletionSignal = "poweroff"
+				c.Generalize.Enabled = &enabled
+			},
+			expected: []string{"generalize requires a communicator on Windows"},
+		},
+		"windows generalization with shutdown command": {
+			mutate: func(c *Config) {
+				enabled := true
+				c.KubevirtOsPreference = "windows.2k22"
+				c.ReadyStrategy = "agent"
+				c.Comm.Type = "winrm"
+				c.ShutdownCommand = "shutdown /s /t 5 /f"
+				c.Generalize.Enabled = &enabled
+			},
+			expected: []string{"shutdown_command cannot be used along with the Windows generalization"},
+		},
+		"negative sparsify timeout": {
+			mutate: func(c *Config) {
+				c.Sparsify = true
+				c.SparsifyTimeOut = -time.Minute
+			},
+			expected: []string{"sparsify_timeout"},
+		},
+		"unsupported output format": {
+			mutate: func(c *Config) {
+				c.OutputFormat = "vdi"
+			},
+			expected: []string{"unsupported output_format 'vdi'"},
+		},
+		"valid output format": {
+			mutate: func(c *Config) {
+				c.OutputFormat = "vmdk"
+			},
+		},
+		"valid generalize root password": {
+			mutate: func(c *Config) {
+				c.Generalize.RootPassword = "locked:password:secret"
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := validConfig()
+			testCase.mutate(&config)
+
+			errs := config.validate()
+			if len(testCase.expected) == 0 && len(errs) > 0 {
+				t.Fatalf("expected no error, got: %v", errs)
+			}
+			for _, expected := range testCase.expected {
+				found := false
+				for _, err := range errs {
+					if strings.Contains(err.Error(), expected) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected an error containing %q, got: %v", expected, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTolerations(t *testing.T) {
+	tolerations, err := decodeTolerations([]map[string]string{
+		{"key": "pelo.tech/kvm", "operator": "Equal", "value": "true", "effect": "NoSchedule"},
+		{"key": "node.kubernetes.io/unreachable", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": "300"},
+	})
+	if err != nil || len(tolerations) != 2 {
+		t.Fatalf("expected two tolerations, got: %v, %v", tolerations, err)
+	}
+	if seconds := tolerations[1].TolerationSeconds; seconds == nil || *seconds != 300 {
+		t.Fatalf("expected the toleration seconds to be decoded, got: %v", seconds)
+	}
+
+	_, err = decodeTolerations([]map[string]string{
+		{"key": "pelo.tech/kvm", "operator": "Equals"},
+		{"kye": "pelo.tech/kvm"},
+		{"key": "pelo.tech/kvm", "effect": "NoExecute", "tolerationSeconds": "5m"},
+		{"key": "pelo.tech/kvm", "effect": "NoSchedule", "tolerationSeconds": "300"},
+	})
+	for index := range 4 {
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("kubernetes_tolerations[%d]", index)) {
+			t.Fatalf("expected every toleration to be reported, got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package iso
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	buildercommon "packer-plugin-kubevirt/builder/common"
+	"packer-plugin-kubevirt/builder/common/k8s/generator"
+	"packer-plugin-kubevirt/builder/common/vm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultGeneralizeOperations reset the identity of the guest while keeping its configuration
+	DefaultGeneralizeOperations = []string{"bash-history", "machine-id", "user-account"}
+	// DefaultKeepUserAccounts is the communicator user created by the default cloud-init
+	DefaultKeepUserAccounts = []string{buildercommon.VirtualMachineUsername}
+
+	sysprepOperationRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// GeneralizeConfig configures the 'virt-sysprep' Job run on the Linux disk before the export, and 'sysprep' run on
+// Windows guests through the communicator. Windows images only use the image, resources, timeout and answer file.
+type GeneralizeConfig struct {
+	Enabled            *bool             `mapstructure:"enabled" required:"false"`
+	Operations         []string          `mapstructure:"operations" required:"false"`
+	DisabledOperations []string          `mapstructure:"disabled_operations" required:"false"`
+	KeepUserAccounts   []string          `mapstructure:"keep_user_accounts" required:"false"`
+	RemoveUserAccounts []string          `mapstructure:"remove_user_accounts" required:"false"`
+	RunCommands        []string          `mapstructure:"run_commands" required:"false"`
+	FirstbootCommands  []string          `mapstructure:"firstboot_commands" required:"false"`
+	RootPassword       string            `mapstructure:"root_password" required:"false"`
+	Image              string            `mapstructure:"image" required:"false"`
+	Requests           map[string]string `mapstructure:"requests" required:"false"`
+	Limits             map[string]string `mapstructure:"limits" required:"false"`
+	Timeout            time.Duration     `mapstructure:"timeout" required:"false"`
+	WindowsUnattend    string            `mapstructure:"windows_unattend" required:"false"`
+}
+
+func (c *GeneralizeConfig) prepare(family vm.OsFamily, commType string) {
+	if c.Enabled == nil {
+		// Windows guests are generalized through the communicator
+		enabled := family != vm.Windows || commType != "none"
+		c.Enabled = &enabled
+	}
+	if len(c.Operations) == 0 {
+		c.Operations = DefaultGeneralizeOperations
+	}
+	if len(c.KeepUserAccounts) == 0 && len(c.RemoveUserAccounts) == 0 {
+		c.KeepUserAccounts = DefaultKeepUserAccounts
+	}
+	if c.Image == "" {
+		c.Image = generator.DefaultGuestFSImage
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 2 * time.Minute
+		if family == vm.Windows {
+			c.Timeout = 15 * time.Minute
+		}
+	}
+}
+
+func (c *GeneralizeConfig) validate() []error {
+	var errs []error
+
+	operations := append(append([]string{}, c.Operations...), c.DisabledOperations...)
+	for _, operation := range operations {
+		if !sysprepOperationRegex.MatchString(operation) {
+			errs = append(errs, fmt.Errorf("generalize operation %q is invalid, operations are lowercase names such as 'machine-id'", operation))
+		}
+	}
+	for _, command := range append(append([]string{}, c.RunCommands...), c.FirstbootCommands...) {
+		if strings.TrimSpace(command) == "" {
+			errs = append(errs, fmt.Errorf("generalize commands cannot be empty"))
+			break
+		}
+	}
+	if c.RootPassword != "" {
+		if err := validateRootPassword("generalize.root_password", c.RootPassword); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, quantity := range c.Requests {
+		if err := buildercommon.ValidateQuantity(fmt.Sprintf("generalize.requests[%s]", name), quantity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, quantity := range c.Limits {
+		if err := buildercommon.ValidateQuantity(fmt.Sprintf("generalize.limits[%s]", name), quantity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := buildercommon.ValidateTimeout("generalize.timeout", c.Timeout); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// validateRootPassword accepts the selectors usable from the Job, 'file:' would refer to the container filesystem
+func validateRootPassword(field, selector string) error {
+	selector = strings.TrimPrefix(selector, "locked:")
+	if selector == "random" || selector == "disabled" || (strings.HasPrefix(selector, "password:") && selector != "password:") {
+		return nil
+	}
+	return fmt.Errorf("unsupported %s selector, allowed values: 'password:<password>', 'random', 'disabled', optionally prefixed with 'locked:'", field)
+}
+
+func (c *GeneralizeConfig) options() generator.GeneralizeOptions {
+	operations := append([]string{}, c.Operations...)
+	for _, operation := range c.DisabledOperations {
+		operations = append(operations, "-"+operation)
+	}
+
+	return generator.GeneralizeOptions{
+		Image:              c.Image,
+		Operations:         operations,
+		KeepUserAccounts:   c.KeepUserAccounts,
+		RemoveUserAccounts: c.RemoveUserAccounts,
+		RunCommands:        c.RunCommands,
+		FirstbootCommands:  c.FirstbootCommands,
+		RootPassword:       c.RootPassword,
+		Resources: v1.ResourceRequirements{
+			Requests: resourceList(c.Requests),
+			Limits:   resourceList(c.Limits),
+		},
+	}
+}
+
+// resourceList expects quantities checked by validate
+func resourceList(quantities map[string]string) v1.ResourceList {
+	if len(quantities) == 0 {
+		return nil
+	}
+	list := v1.ResourceList{}
+	for name, quantity := range quantities {
+		list[v1.ResourceName(name)] = resource.MustParse(quantity)
+	}
+	return list
+}
@@ -6,6 +6,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	exportv1 "kubevirt.io/api/export/v1beta1"
+	buildercommon "packer-plugin-kubevirt/builder/common"
 	"packer-plugin-kubevirt/builder/common/k8s"
 	"packer-plugin-kubevirt/builder/common/steps"
 	"path"
@@ -23,6 +24,7 @@ const (
 
 type S3UploaderOptions struct {
 	Name               string
+	FileName           string
 	Namespace          string
 	ServiceAccountName *string
 
@@ -51,8 +53,10 @@ func GenerateS3UploaderSecret(job *batchv1.Job, opts S3UploaderOptions) *corev1.
 
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      buildJobSecretName(opts.Name),
-			Namespace: opts.Namespace,
+			Name:        buildJobSecretName(opts.Name),
+			Namespace:   opts.Namespace,
+			Labels:      buildercommon.InheritLabels(job.Labels),
+			Annotations: buildercommon.InheritAnnotations(job.Annotations),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job")),
 			},
@@ -66,18 +70,23 @@ func buildJobSecretName(name string) string {
 }
 
 func GenerateS3UploaderJob(export *exportv1.VirtualMachineExport, opts S3UploaderOptions) *batchv1.Job {
-	filename := fmt.Sprintf("%s.img.gz", opts.Name)
+	filename := opts.FileName
 
 	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("s3-uploader-%s", opts.Name),
-			Namespace: opts.Namespace,
+			Name:        fmt.Sprintf("s3-uploader-%s", opts.Name),
+			Namespace:   opts.Namespace,
+			Labels:      buildercommon.InheritLabels(export.Labels),
+			Annotations: buildercommon.InheritAnnotations(export.Annotations),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(export, exportv1.SchemeGroupVersion.WithKind(k8s.VirtualMachineExportKind)),
 			},
 		},
 		Spec: batchv1.JobSpec{
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: buildercommon.InheritLabels(export.Labels),
+				},
 				Spec: corev1.PodSpec{
 					ServiceAccountName: *opts.ServiceAccountName,
 					InitContainers: []corev1.Container{
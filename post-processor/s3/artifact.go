@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"fmt"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const BuilderId = "packer.post-processor.s3"
+
+// Artifact points to the image uploaded to S3, it does not own the object which is never deleted
+type Artifact struct {
+	Bucket string
+	Key    string
+	Region string
+	// source provides the state of the build, such as the generated data, to the post-processors down the chain
+	source packersdk.Artifact
+}
+
+func (a *Artifact) BuilderId() string {
+	return BuilderId
+}
+
+func (a *Artifact) Files() []string {
+	return []string{}
+}
+
+func (a *Artifact) Id() string {
+	return fmt.Sprintf("s3://%s/%s", a.Bucket, a.Key)
+}
+
+func (a *Artifact) String() string {
+	return fmt.Sprintf("image uploaded to %s (%s)", a.Id(), a.Region)
+}
+
+func (a *Artifact) State(name string) interface{} {
+	return a.source.State(name)
+}
+
+func (a *Artifact) Destroy() error {
+	return nil
+}
@@ -17,10 +17,14 @@ import (
 	"packer-plugin-kubevirt/builder/common/k8s"
 	"packer-plugin-kubevirt/builder/common/k8s/generator"
 	"packer-plugin-kubevirt/post-processor/common"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 )
 
+var s3BucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
 type Config struct {
 	packercommon.PackerConfig `mapstructure:",squash"`
 	ctx                       interpolate.Context
@@ -43,7 +47,7 @@ func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMap
 
 func (p *PostProcessor) Configure(raws ...interface{}) error {
 	err := config.Decode(&p.config, &config.DecodeOpts{
-		PluginType:         "packer.post-processor.s3",
+		PluginType:         BuilderId,
 		Interpolate:        true,
 		InterpolateContext: &p.config.ctx,
 		InterpolateFilter: &interpolate.RenderFilter{
@@ -54,28 +58,78 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		return err
 	}
 
+	if p.config.UploadTimeOut == 0 {
+		p.config.UploadTimeOut = 10 * time.Minute
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, p.config.validate()...)
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+
 	p.virtClient, err = k8s.GetKubevirtClient()
 	if err != nil {
 		return err
 	}
 
-	if p.config.UploadTimeOut == 0 {
-		p.config.UploadTimeOut = 10 * time.Minute
+	return nil
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+
+	if err := buildercommon.ValidateRequired("s3_bucket", c.S3Bucket); err != nil {
+		errs = append(errs, err)
+	} else if !s3BucketNameRegexp.MatchString(c.S3Bucket) {
+		errs = append(errs, fmt.Errorf("s3_bucket %q is invalid: must be 3 to 63 characters of lowercase letters, numbers, dots and hyphens", c.S3Bucket))
 	}
 
-	if (p.config.AWSAccessKeyId == "" || p.config.AWSSecretAccessKey == "") && p.config.ServiceAccountName == "" {
-		return fmt.Errorf("either AWS access keys or service account name must be provided")
+	if strings.HasPrefix(c.S3KeyPrefix, "/") {
+		errs = append(errs, fmt.Errorf("s3_key_prefix %q must not start with '/'", c.S3KeyPrefix))
 	}
 
-	return nil
+	if err := buildercommon.ValidateRequired("aws_region", c.AWSRegion); err != nil {
+		errs = append(errs, err)
+	}
+
+	if (c.AWSAccessKeyId == "" || c.AWSSecretAccessKey == "") && c.ServiceAccountName == "" {
+		errs = append(errs, fmt.Errorf("either AWS access keys or service account name must be provided"))
+	}
+	if (c.AWSAccessKeyId == "") != (c.AWSSecretAccessKey == "") {
+		errs = append(errs, fmt.Errorf("aws_access_key_id and aws_secret_access_key must be provided together"))
+	}
+	if c.ServiceAccountName != "" {
+		if err := buildercommon.ValidateDNS1123Subdomain("service_account_name", c.ServiceAccountName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := buildercommon.ValidateTimeout("upload_timeout", c.UploadTimeOut); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
 }
 
-func (p *PostProcessor) PostProcess(_ context.Context, ui packersdk.Ui, source packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, source packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
 	ns := source.State(buildercommon.NamespaceArtifactKey).(string)
 	name := source.State(buildercommon.VirtualMachineExportNameArtifactKey).(string)
 	token := source.State(buildercommon.VirtualMachineExportTokenArtifactKey).(string)
+	imageName, _ := source.State(buildercommon.ImageNameArtifactKey).(string)
+	if imageName == "" {
+		imageName = name
+	}
+	outputFormat, _ := source.State(buildercommon.OutputFormatArtifactKey).(string)
+	exportFormat := exportv1.KubeVirtGz
+	fileName := fmt.Sprintf("%s.%s.gz", imageName, generator.OutputFormat(outputFormat).FileExtension())
+	if generator.OutputFormat(outputFormat) == generator.OutputFormatOva {
+		// The disk of the package is already compressed, and vSphere imports the '.ova' as is
+		exportFormat = exportv1.KubeVirtRaw
+		fileName = fmt.Sprintf("%s.ova", imageName)
+	}
 
-	export, err := p.virtClient.VirtualMachineExport(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	export, err := p.virtClient.VirtualMachineExport(ns).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, false, false, fmt.Errorf("failed to get Virtual Machine Export: %w", err)
 	}
@@ -86,9 +140,10 @@ func (p *PostProcessor) PostProcess(_ context.Context, ui packersdk.Ui, source p
 		return nil, true, true, fmt.Errorf("failed to get any data from Virtual Machine Export %s/%s: %v", ns, name, export.Status)
 	}
 	for _, vol := range export.Status.Links.Internal.Volumes {
-		if strings.HasSuffix(vol.Name, string(generator.SourceDataVolumeSuffix)) { // may need better logic if many volumes
+		// The export holds either the disks of the VM or the converted image
+		if strings.HasSuffix(vol.Name, string(generator.SourceDataVolumeSuffix)) || strings.HasSuffix(vol.Name, generator.ConvertedVolumeSuffix) {
 			for _, volumeFormat := range vol.Formats {
-				if volumeFormat.Format == exportv1.KubeVirtGz {
+				if volumeFormat.Format == exportFormat {
 					exportServerUrl = volumeFormat.Url
 				}
 			}
@@ -100,6 +155,7 @@ func (p *PostProcessor) PostProcess(_ context.Context, ui packersdk.Ui, source p
 
 	options := common.S3UploaderOptions{
 		Name:                    export.Name,
+		FileName:                fileName,
 		Namespace:               export.Namespace,
 		ExportServerUrl:         exportServerUrl,
 		ExportServerToken:       token,
@@ -117,28 +173,53 @@ func (p *PostProcessor) PostProcess(_ context.Context, ui packersdk.Ui, source p
 		options.AWSSecretAccessKey = &p.config.AWSSecretAccessKey
 	}
 
+	// Uploads are not resumable, '-force' is the only way to replace a job left over by a previous run
+	conflictPolicy := buildercommon.ConflictPolicyFail
+	if p.config.PackerForce {
+		conflictPolicy = buildercommon.ConflictPolicyRecreate
+	}
+
 	job := common.GenerateS3UploaderJob(export, options)
-	job, err = p.virtClient.BatchV1().Jobs(export.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	events := &k8s.EventStreamer{
+		Client:    p.virtClient,
+		Ui:        ui,
+		Namespace: export.Namespace,
+		Names:     []string{job.Name},
+	}
+	if err := events.Start(ctx); err != nil {
+		ui.Message(fmt.Sprintf("warning events will not be shown: %s", err))
+	}
+	defer events.Stop()
+
+	job, err = k8s.CreateResource(ctx, k8s.JobOperations(p.virtClient, export.Namespace), job, conflictPolicy)
 	if err != nil {
 		return nil, true, true, fmt.Errorf("failed to deploy S3 uploader job: %w", err)
 	}
 
 	secret := common.GenerateS3UploaderSecret(job, options)
-	_, err = p.virtClient.CoreV1().Secrets(export.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	_, err = k8s.CreateResource(ctx, k8s.SecretOperations(p.virtClient, export.Namespace), secret, conflictPolicy)
 	if err != nil {
 		return nil, true, true, fmt.Errorf("failed to create S3 uploader secret: %w", err)
 	}
 
-	err = k8s.WaitForJobCompletion(p.virtClient.BatchV1(), ui, job, p.config.UploadTimeOut)
+	err = k8s.WaitForJobCompletion(ctx, p.virtClient, ui, job, p.config.UploadTimeOut)
 	if err != nil {
 		return nil, true, true, fmt.Errorf("error with 'S3 uploader' job: %w", err)
 	}
 
-	return source, true, true, nil
+	// The source artifact is not kept by default, destroying it releases the build resources such as an ephemeral namespace
+	return &Artifact{
+		Bucket: p.config.S3Bucket,
+		Key:    path.Join(p.config.S3KeyPrefix, fileName),
+		Region: p.config.AWSRegion,
+		source: source,
+	}, false, false, nil
 }
 
 func (p *PostProcessor) cleanupResources(ui packersdk.Ui, ns, name string) {
-	err := p.virtClient.VirtualMachineExport(ns).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	ctx, cancel := k8s.CleanupContext()
+	defer cancel()
+	err := p.virtClient.VirtualMachineExport(ns).Delete(ctx, name, metav1.DeleteOptions{})
 	if err == nil {
 		ui.Message(fmt.Sprintf("Virtual Machine Export %s/%s has been deleted", ns, name))
 	} else {